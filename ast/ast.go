@@ -9,6 +9,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -34,6 +35,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -52,6 +60,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 // Expression Statement
@@ -62,6 +71,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -78,6 +88,7 @@ type BakeStatement struct {
 
 func (bs *BakeStatement) statementNode()       {}
 func (bs *BakeStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BakeStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BakeStatement) String() string {
 	var out bytes.Buffer
 
@@ -93,6 +104,29 @@ func (bs *BakeStatement) String() string {
 	return out.String()
 }
 
+// Recipe Declaration
+type RecipeDeclaration struct {
+	Token  token.Token // the 'recipe' token
+	Name   *Identifier
+	Recipe *RecipeLiteral
+}
+
+func (rd *RecipeDeclaration) statementNode()       {}
+func (rd *RecipeDeclaration) TokenLiteral() string { return rd.Token.Literal }
+func (rd *RecipeDeclaration) Pos() token.Position  { return rd.Token.Pos }
+func (rd *RecipeDeclaration) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(rd.TokenLiteral() + " ")
+	out.WriteString(rd.Name.String())
+	out.WriteString("(")
+	out.WriteString(ParameterList(rd.Recipe.Parameters, rd.Recipe.Defaults, rd.Recipe.Rest))
+	out.WriteString(")")
+	out.WriteString(rd.Recipe.Body.String())
+
+	return out.String()
+}
+
 // Serves Statement
 type ServesStatement struct {
 	Token       token.Token // the 'serves' token
@@ -101,6 +135,7 @@ type ServesStatement struct {
 
 func (rs *ServesStatement) statementNode()       {}
 func (rs *ServesStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ServesStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ServesStatement) String() string {
 	var out bytes.Buffer
 
@@ -123,8 +158,20 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// Float Literal
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 // String Literal
 type StringLiteral struct {
 	Token token.Token
@@ -133,6 +180,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 // Prefix Expression
@@ -145,6 +193,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -167,6 +216,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -179,6 +229,30 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// Assign Expression
+
+type AssignExpression struct {
+	Token    token.Token // the assignment token, eg: to, = or +=
+	Target   Expression  // Identifier or IndexExpression
+	Operator string      // the infix operator of compound assignments, empty otherwise
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Token.Pos }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.TokenLiteral() + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // Boolean
 
 type Boolean struct {
@@ -188,6 +262,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // If
@@ -201,6 +276,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -217,6 +293,81 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// While Statement
+
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while (")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(") { ")
+	out.WriteString(ws.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// For Statement
+
+type ForStatement struct {
+	Token    token.Token // the 'for' token
+	Key      *Identifier // the element, or the index/key when Value is set
+	Value    *Identifier // optional, the element when iterating with two names
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Key.String())
+	if fs.Value != nil {
+		out.WriteString(", " + fs.Value.String())
+	}
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") { ")
+	out.WriteString(fs.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// Break Statement
+
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+// Continue Statement
+
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+
 // Block Statement
 
 type BlockStatement struct {
@@ -226,6 +377,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) expressionNode()      {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -241,28 +393,44 @@ func (bs *BlockStatement) String() string {
 type RecipeLiteral struct {
 	Token      token.Token // the 'recipe' token
 	Parameters []*Identifier
+	Defaults   []Expression // default value of each parameter, nil when it has none
+	Rest       *Identifier  // collects extra arguments into an array, eg: ...rest
 	Body       *BlockStatement
 }
 
 func (rl *RecipeLiteral) expressionNode()      {}
 func (rl *RecipeLiteral) TokenLiteral() string { return rl.Token.Literal }
+func (rl *RecipeLiteral) Pos() token.Position  { return rl.Token.Pos }
 func (rl *RecipeLiteral) String() string {
 	var out bytes.Buffer
 
-	params := []string{}
-	for _, p := range rl.Parameters {
-		params = append(params, p.String())
-	}
-
 	out.WriteString(rl.TokenLiteral())
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(ParameterList(rl.Parameters, rl.Defaults, rl.Rest))
 	out.WriteString(")")
 	out.WriteString(rl.Body.String())
 
 	return out.String()
 }
 
+// ParameterList formats recipe parameters as they are written, eg: a, b = 2, ...rest
+func ParameterList(params []*Identifier, defaults []Expression, rest *Identifier) string {
+	list := []string{}
+	for i, p := range params {
+		if i < len(defaults) && defaults[i] != nil {
+			list = append(list, p.String()+" = "+defaults[i].String())
+		} else {
+			list = append(list, p.String())
+		}
+	}
+
+	if rest != nil {
+		list = append(list, "..."+rest.String())
+	}
+
+	return strings.Join(list, ", ")
+}
+
 // Call Expression
 
 type CallExpression struct {
@@ -273,6 +441,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -298,6 +467,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -323,6 +493,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -339,17 +510,24 @@ func (ie *IndexExpression) String() string {
 
 type HashLiteral struct {
 	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Pairs []HashLiteralPair
+}
+
+// HashLiteralPair is a key and its value, pairs keep the order they were written in
+type HashLiteralPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -22,7 +22,34 @@ func TestString(t *testing.T) {
 		},
 	}
 
-	if program.String() != "let my_var = another_var;" {
+	if program.String() != "bake my_var to another_var;" {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestLoopString(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	body := &BlockStatement{Statements: []Statement{
+		&ExpressionStatement{Expression: &CallExpression{Recipe: ident("plates"), Arguments: []Expression{ident("x")}}},
+	}}
+
+	tests := []struct {
+		node     Statement
+		expected string
+	}{
+		{&WhileStatement{Token: token.Token{Type: token.WHILE, Literal: "while"}, Condition: ident("a"), Body: body},
+			"while (a) { plates(x) }"},
+		{&ForStatement{Token: token.Token{Type: token.FOR, Literal: "for"}, Key: ident("x"), Iterable: ident("xs"), Body: body},
+			"for (x in xs) { plates(x) }"},
+		{&ForStatement{Token: token.Token{Type: token.FOR, Literal: "for"}, Key: ident("i"), Value: ident("x"), Iterable: ident("xs"), Body: body},
+			"for (i, x in xs) { plates(x) }"},
+	}
+
+	for _, tt := range tests {
+		if tt.node.String() != tt.expected {
+			t.Errorf("wrong String(). want=%q, got=%q", tt.expected, tt.node.String())
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpNull
+	OpTrue
+	OpFalse
+
+	// Operators
+	OpBinary // operand: OPERATOR_*
+	OpMinus
+	OpBang
+
+	// Control flow
+	OpJump
+	OpJumpNotTruthy
+	OpJumpIfLocalSet // skips the default value of a parameter given by the caller
+	OpIterInit
+	OpIterNext // operands: mode (ITERATE_*), jump target once the iterator is exhausted
+
+	// Bindings
+	OpGetGlobal
+	OpSetGlobal
+	OpAssignGlobal // operands: index, compound operator (0 or OPERATOR_* + 1)
+	OpGetLocal
+	OpSetLocal
+	OpAssignLocal
+	OpGetOuter // operands: depth, index
+	OpAssignOuter
+	OpName // names the recipe on top of the stack after the binding it is baked to
+
+	// Data structures
+	OpArray
+	OpHash
+	OpIndex
+	OpSetIndex // operand: compound operator
+
+	// Recipes
+	OpClosure
+	OpCall
+	OpReturnValue
+	OpReturn
+)
+
+// Operands of OpBinary, compound assignments use them plus one as 0 means a plain assignment
+const (
+	OPERATOR_ADD = iota
+	OPERATOR_SUBTRACT
+	OPERATOR_MULTIPLY
+	OPERATOR_DIVIDE
+	OPERATOR_MODULO
+	OPERATOR_LESS
+	OPERATOR_GREATER
+	OPERATOR_EQUAL
+	OPERATOR_NOT_EQUAL
+)
+
+var BINARY_OPERATORS = []string{
+	OPERATOR_ADD:       "+",
+	OPERATOR_SUBTRACT:  "-",
+	OPERATOR_MULTIPLY:  "*",
+	OPERATOR_DIVIDE:    "/",
+	OPERATOR_MODULO:    "%",
+	OPERATOR_LESS:      "<",
+	OPERATOR_GREATER:   ">",
+	OPERATOR_EQUAL:     "==",
+	OPERATOR_NOT_EQUAL: "!=",
+}
+
+// Iteration modes of OpIterNext
+const (
+	ITERATE_SINGLE = 0 // pushes the element, or the key for hashes
+	ITERATE_PAIR   = 1 // pushes the index (or key) then the element (or value)
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+
+	OpBinary: {"OpBinary", []int{1}},
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+
+	OpJump:           {"OpJump", []int{2}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJumpIfLocalSet: {"OpJumpIfLocalSet", []int{2, 2}},
+	OpIterInit:       {"OpIterInit", []int{}},
+	OpIterNext:       {"OpIterNext", []int{1, 2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpAssignGlobal: {"OpAssignGlobal", []int{2, 1}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpAssignLocal:  {"OpAssignLocal", []int{2, 1}},
+	OpGetOuter:     {"OpGetOuter", []int{1, 2}},
+	OpAssignOuter:  {"OpAssignOuter", []int{1, 2, 1}},
+	OpName:         {"OpName", []int{2}},
+
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{1}},
+
+	OpClosure:     {"OpClosure", []int{2}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes an instruction, operands are big endian
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	length := 1
+	for _, w := range def.OperandWidths {
+		length += w
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction, returning them and how many bytes they took
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// String disassembles the instructions, one per line prefixed by its offset
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i += 1
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+	}
+
+	out := def.Name
+	for _, operand := range operands {
+		out += fmt.Sprintf(" %d", operand)
+	}
+	return out
+}
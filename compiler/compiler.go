@@ -0,0 +1,717 @@
+package compiler
+
+import (
+	"cottagepie/ast"
+	"cottagepie/code"
+	"cottagepie/evaluator"
+	"cottagepie/object"
+	"cottagepie/token"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Bytecode is a compiled program, the vm runs Main as a recipe without parameters
+type Bytecode struct {
+	Main        *object.CompiledRecipe
+	Constants   []object.Object
+	GlobalNames []string // the name of each global slot
+}
+
+type Compiler struct {
+	constants []object.Object
+	symbols   *SymbolTable
+	scopes    []*compilationScope
+}
+
+// compilationScope is the recipe being compiled, the main program being the outermost one
+type compilationScope struct {
+	instructions code.Instructions
+	positions    map[int]token.Position
+	loops        []*loop
+}
+
+// loop tracks where break and continue statements jump to
+type loop struct {
+	start  int   // continue jumps back here
+	breaks []int // break jumps, patched once the end of the loop is known
+}
+
+func New() *Compiler {
+	return NewWithState(NewSymbolTable(), []object.Object{})
+}
+
+// NewWithState compiles against the globals and constants of previous compilations, for the REPL
+func NewWithState(symbols *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants: constants,
+		symbols:   symbols,
+		scopes:    []*compilationScope{newCompilationScope()},
+	}
+}
+
+func newCompilationScope() *compilationScope {
+	return &compilationScope{positions: make(map[int]token.Position)}
+}
+
+// Compile lowers the program to the main recipe, which serves the value of its last
+// statement like the evaluator does
+func (c *Compiler) Compile(program *ast.Program) error {
+	pushed, err := c.compileStatements(program.Statements, true)
+	if err != nil {
+		return err
+	}
+
+	if pushed {
+		c.emit(code.OpReturnValue)
+	} else {
+		c.emit(code.OpReturn)
+	}
+
+	if len(c.currentScope().instructions) > math.MaxUint16 {
+		return fmt.Errorf("program too large, jumps are limited to %d bytes", math.MaxUint16)
+	}
+	if len(c.constants) > math.MaxUint16 {
+		return fmt.Errorf("program too large, it is limited to %d constants", math.MaxUint16)
+	}
+
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	scope := c.currentScope()
+
+	return &Bytecode{
+		Main:        &object.CompiledRecipe{Instructions: scope.instructions, Positions: scope.positions},
+		Constants:   c.constants,
+		GlobalNames: c.globals().Names(),
+	}
+}
+
+// compileStatements compiles a program or block, recipe declarations first. With keepValue
+// the value of the last statement is left on the stack, pushed tells whether it had one
+func (c *Compiler) compileStatements(statements []ast.Statement, keepValue bool) (pushed bool, err error) {
+	for _, statement := range statements {
+		if declaration, ok := statement.(*ast.RecipeDeclaration); ok {
+			if err := c.compileRecipeDeclaration(declaration); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	last := -1
+	if keepValue {
+		last = lastValueStatement(statements)
+	}
+
+	for i, statement := range statements {
+		if _, ok := statement.(*ast.RecipeDeclaration); ok {
+			continue
+		}
+
+		pushed, err = c.compileStatement(statement, i == last)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return pushed, nil
+}
+
+// lastValueStatement is the index of the statement giving its value to a block, declarations have none
+func lastValueStatement(statements []ast.Statement) int {
+	for i := len(statements) - 1; i >= 0; i-- {
+		if _, ok := statements[i].(*ast.RecipeDeclaration); !ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// compileBlock always leaves a value on the stack when keepValue is set, null if the block has none
+func (c *Compiler) compileBlock(block *ast.BlockStatement, keepValue bool) error {
+	pushed, err := c.compileStatements(block.Statements, keepValue)
+	if err != nil {
+		return err
+	}
+
+	if keepValue && !pushed {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileStatement(statement ast.Statement, keepValue bool) (bool, error) {
+	switch node := statement.(type) {
+	case *ast.ExpressionStatement:
+		if err := c.compile(node.Expression); err != nil {
+			return false, err
+		}
+		if keepValue {
+			return true, nil
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BakeStatement:
+		if err := c.compile(node.Value); err != nil {
+			return false, err
+		}
+		c.emit(code.OpName, c.addConstant(&object.String{Value: node.Name.Value}))
+		c.emitSet(c.symbols.Define(node.Name.Value))
+
+	case *ast.ServesStatement:
+		if err := c.compile(node.ServesValue); err != nil {
+			return false, err
+		}
+		c.emit(code.OpReturnValue)
+
+	// Loops are worth null like in the evaluator
+	case *ast.WhileStatement:
+		if err := c.compileWhileStatement(node); err != nil {
+			return false, err
+		}
+		if keepValue {
+			c.emit(code.OpNull)
+			return true, nil
+		}
+
+	case *ast.ForStatement:
+		if err := c.compileForStatement(node); err != nil {
+			return false, err
+		}
+		if keepValue {
+			c.emit(code.OpNull)
+			return true, nil
+		}
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return false, fmt.Errorf("%s: break outside of a loop", node.Pos())
+		}
+		loop.breaks = append(loop.breaks, c.emit(code.OpJump, 0))
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return false, fmt.Errorf("%s: continue outside of a loop", node.Pos())
+		}
+		c.emit(code.OpJump, loop.start)
+
+	default:
+		return false, fmt.Errorf("%s: cannot compile %T", statement.Pos(), statement)
+	}
+
+	return false, nil
+}
+
+func (c *Compiler) compile(node ast.Expression) error {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.Identifier:
+		c.loadIdentifier(node)
+
+	case *ast.PrefixExpression:
+		if err := c.compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "-":
+			c.emitAt(node, code.OpMinus)
+		case "!":
+			c.emitAt(node, code.OpBang)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		operator, ok := binaryOperator(node.Operator)
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		if err := c.compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.compile(node.Right); err != nil {
+			return err
+		}
+		c.emitAt(node, code.OpBinary, operator)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			if err := c.compile(element); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emitAt(node, code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.compile(node.Index); err != nil {
+			return err
+		}
+		c.emitAt(node, code.OpIndex)
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	case *ast.RecipeLiteral:
+		recipe, err := c.compileRecipe(node)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpClosure, c.addConstant(recipe))
+
+	case *ast.CallExpression:
+		if len(node.Arguments) > math.MaxUint8 {
+			return fmt.Errorf("%s: too many arguments, the limit is %d", node.Pos(), math.MaxUint8)
+		}
+		if err := c.compile(node.Recipe); err != nil {
+			return err
+		}
+		for _, argument := range node.Arguments {
+			if err := c.compile(argument); err != nil {
+				return err
+			}
+		}
+		c.emitAt(node, code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 0)
+
+	if err := c.compileBlock(node.Consequence, true); err != nil {
+		return err
+	}
+
+	jump := c.emit(code.OpJump, 0)
+	c.patchJump(jumpNotTruthy)
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlock(node.Alternative, true); err != nil {
+		return err
+	}
+
+	c.patchJump(jump)
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	start := len(c.currentScope().instructions)
+
+	if err := c.compile(node.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(code.OpJumpNotTruthy, 0)
+
+	if err := c.compileLoopBody(node.Body, start); err != nil {
+		return err
+	}
+
+	c.patchJump(exit)
+	c.endLoop()
+	return nil
+}
+
+// compileForStatement keeps the iterator on the stack for the whole loop, both exhausting
+// it and breaking out of the loop land on the OpPop removing it
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if err := c.compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emitAt(node, code.OpIterInit)
+
+	mode := code.ITERATE_SINGLE
+	if node.Value != nil {
+		mode = code.ITERATE_PAIR
+	}
+
+	start := len(c.currentScope().instructions)
+	next := c.emit(code.OpIterNext, mode, 0)
+	if node.Value != nil {
+		c.emitSet(c.symbols.Define(node.Value.Value))
+	}
+	c.emitSet(c.symbols.Define(node.Key.Value))
+
+	if err := c.compileLoopBody(node.Body, start); err != nil {
+		return err
+	}
+
+	c.patchJump(next)
+	c.endLoop()
+	c.emit(code.OpPop)
+	return nil
+}
+
+// compileLoopBody compiles one iteration followed by the jump back to start
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, start int) error {
+	scope := c.currentScope()
+	scope.loops = append(scope.loops, &loop{start: start})
+
+	if err := c.compileBlock(body, false); err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, start)
+	return nil
+}
+
+// endLoop points the break jumps of the innermost loop at the current instruction
+func (c *Compiler) endLoop() {
+	scope := c.currentScope()
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, jump := range loop.breaks {
+		c.patchJump(jump)
+	}
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.currentScope().loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// compileAssignExpression leaves the assigned value on the stack, compound operators
+// are applied by the vm so the target is only read once
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	operator := 0
+	if node.Operator != "" {
+		index, ok := binaryOperator(node.Operator)
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		operator = index + 1
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+
+		symbol, ok := c.symbols.Resolve(target.Value)
+		if !ok {
+			symbol = c.globals().Define(target.Value)
+		}
+
+		switch symbol.Scope {
+		case GLOBAL_SCOPE:
+			c.emitAt(node, code.OpAssignGlobal, symbol.Index, operator)
+		case LOCAL_SCOPE:
+			c.emitAt(node, code.OpAssignLocal, symbol.Index, operator)
+		case OUTER_SCOPE:
+			c.emitAt(node, code.OpAssignOuter, symbol.Depth, symbol.Index, operator)
+		}
+
+	case *ast.IndexExpression:
+		if err := c.compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+		c.emitAt(node, code.OpSetIndex, operator)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	return nil
+}
+
+// loadIdentifier resolves a name at compile time: locals, enclosing recipes, globals then built-ins.
+// Unknown names become globals, they may be baked later and the vm reports them if they aren't
+func (c *Compiler) loadIdentifier(node *ast.Identifier) {
+	symbol, ok := c.symbols.Resolve(node.Value)
+	if !ok {
+		if built_in, ok := evaluator.LookupBuiltIn(node.Value); ok {
+			c.emit(code.OpConstant, c.addConstant(built_in))
+			return
+		}
+		symbol = c.globals().Define(node.Value)
+	}
+
+	switch symbol.Scope {
+	case GLOBAL_SCOPE:
+		c.emitAt(node, code.OpGetGlobal, symbol.Index)
+	case LOCAL_SCOPE:
+		c.emitAt(node, code.OpGetLocal, symbol.Index)
+	case OUTER_SCOPE:
+		c.emitAt(node, code.OpGetOuter, symbol.Depth, symbol.Index)
+	}
+}
+
+func (c *Compiler) emitSet(symbol Symbol) {
+	if symbol.Scope == GLOBAL_SCOPE {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
+func (c *Compiler) compileRecipeDeclaration(node *ast.RecipeDeclaration) error {
+	recipe, err := c.compileRecipe(node.Recipe)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpClosure, c.addConstant(recipe))
+	c.emit(code.OpName, c.addConstant(&object.String{Value: node.Name.Value}))
+	c.emitSet(c.symbols.Define(node.Name.Value))
+	return nil
+}
+
+// compileRecipe gives a local slot to the parameters, then to every name the body binds
+// so nested recipes can refer to locals baked after them. Parameters with a default value
+// start with a prologue evaluating it when the caller left the slot empty
+func (c *Compiler) compileRecipe(lit *ast.RecipeLiteral) (*object.CompiledRecipe, error) {
+	c.enterScope()
+
+	for _, param := range lit.Parameters {
+		c.symbols.defineSlot(param.Value)
+	}
+	if lit.Rest != nil {
+		c.symbols.defineSlot(lit.Rest.Value)
+	}
+
+	bindings := []string{}
+	collectBindings(lit.Body, &bindings)
+	for _, name := range bindings {
+		c.symbols.Define(name)
+	}
+
+	numRequired := len(lit.Parameters)
+	for i, def := range lit.Defaults {
+		if def == nil {
+			continue
+		}
+		if i < numRequired {
+			numRequired = i
+		}
+
+		skip := c.emit(code.OpJumpIfLocalSet, i, 0)
+		if err := c.compile(def); err != nil {
+			c.leaveScope()
+			return nil, err
+		}
+		c.emit(code.OpSetLocal, i)
+		c.patchJump(skip)
+	}
+
+	if err := c.compileBlock(lit.Body, true); err != nil {
+		c.leaveScope()
+		return nil, err
+	}
+	c.emit(code.OpReturnValue)
+
+	names := c.symbols.Names()
+	scope := c.leaveScope()
+
+	if len(scope.instructions) > math.MaxUint16 {
+		return nil, fmt.Errorf("%s: recipe too large, jumps are limited to %d bytes", lit.Pos(), math.MaxUint16)
+	}
+
+	return &object.CompiledRecipe{
+		Instructions:  scope.instructions,
+		Positions:     scope.positions,
+		NumLocals:     len(names),
+		NumParameters: len(lit.Parameters),
+		NumRequired:   numRequired,
+		Variadic:      lit.Rest != nil,
+		LocalNames:    names,
+		Literal:       lit,
+	}, nil
+}
+
+// collectBindings appends the names baked, declared or looped over by a node to names,
+// looking into nested blocks but not into nested recipes which have their own scope
+func collectBindings(node ast.Node, names *[]string) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, statement := range node.Statements {
+			collectBindings(statement, names)
+		}
+
+	case *ast.BakeStatement:
+		*names = append(*names, node.Name.Value)
+		collectBindings(node.Value, names)
+
+	case *ast.RecipeDeclaration:
+		*names = append(*names, node.Name.Value)
+
+	case *ast.ForStatement:
+		*names = append(*names, node.Key.Value)
+		if node.Value != nil {
+			*names = append(*names, node.Value.Value)
+		}
+		collectBindings(node.Iterable, names)
+		collectBindings(node.Body, names)
+
+	case *ast.WhileStatement:
+		collectBindings(node.Condition, names)
+		collectBindings(node.Body, names)
+
+	case *ast.ExpressionStatement:
+		collectBindings(node.Expression, names)
+
+	case *ast.ServesStatement:
+		collectBindings(node.ServesValue, names)
+
+	case *ast.IfExpression:
+		collectBindings(node.Condition, names)
+		collectBindings(node.Consequence, names)
+		if node.Alternative != nil {
+			collectBindings(node.Alternative, names)
+		}
+
+	case *ast.PrefixExpression:
+		collectBindings(node.Right, names)
+
+	case *ast.InfixExpression:
+		collectBindings(node.Left, names)
+		collectBindings(node.Right, names)
+
+	case *ast.IndexExpression:
+		collectBindings(node.Left, names)
+		collectBindings(node.Index, names)
+
+	case *ast.AssignExpression:
+		collectBindings(node.Target, names)
+		collectBindings(node.Value, names)
+
+	case *ast.CallExpression:
+		collectBindings(node.Recipe, names)
+		for _, argument := range node.Arguments {
+			collectBindings(argument, names)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			collectBindings(element, names)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			collectBindings(pair.Key, names)
+			collectBindings(pair.Value, names)
+		}
+	}
+}
+
+func binaryOperator(operator string) (int, bool) {
+	for i, op := range code.BINARY_OPERATORS {
+		if op == operator {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) currentScope() *compilationScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+func (c *Compiler) globals() *SymbolTable {
+	table := c.symbols
+	for table.Outer != nil {
+		table = table.Outer
+	}
+	return table
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, newCompilationScope())
+	c.symbols = NewEnclosedSymbolTable(c.symbols)
+}
+
+func (c *Compiler) leaveScope() *compilationScope {
+	scope := c.currentScope()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.symbols = c.symbols.Outer
+	return scope
+}
+
+// emit appends an instruction to the current scope and returns its offset
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	scope := c.currentScope()
+	offset := len(scope.instructions)
+	scope.instructions = append(scope.instructions, code.Make(op, operands...)...)
+	return offset
+}
+
+// emitAt emits an instruction that can fail at runtime, remembering where it comes from
+func (c *Compiler) emitAt(node ast.Node, op code.Opcode, operands ...int) int {
+	offset := c.emit(op, operands...)
+	c.currentScope().positions[offset] = node.Pos()
+	return offset
+}
+
+// patchJump points the jump at offset, whose target is always its last operand, at the next instruction
+func (c *Compiler) patchJump(offset int) {
+	instructions := c.currentScope().instructions
+
+	def, err := code.Lookup(instructions[offset])
+	if err != nil {
+		panic(err)
+	}
+
+	end := offset + 1
+	for _, width := range def.OperandWidths {
+		end += width
+	}
+	binary.BigEndian.PutUint16(instructions[end-2:], uint16(len(instructions)))
+}
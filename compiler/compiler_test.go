@@ -0,0 +1,226 @@
+package compiler
+
+import (
+	"cottagepie/code"
+	"cottagepie/lexer"
+	"cottagepie/object"
+	"cottagepie/parser"
+	"strings"
+	"testing"
+)
+
+func TestResolveSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	outer := NewEnclosedSymbolTable(global)
+	outer.Define("b")
+
+	inner := NewEnclosedSymbolTable(outer)
+	inner.Define("c")
+	inner.Define("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GLOBAL_SCOPE, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: OUTER_SCOPE, Index: 0, Depth: 1}},
+		{"c", Symbol{Name: "c", Scope: LOCAL_SCOPE, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		symbol, ok := inner.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if symbol != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, symbol)
+		}
+	}
+
+	if _, ok := inner.Resolve("d"); ok {
+		t.Errorf("name d resolved but was never defined")
+	}
+
+	if len(inner.Names()) != 1 {
+		t.Errorf("defining a name twice should reuse its slot, got=%v", inner.Names())
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input                string
+		expectedInstructions []code.Instructions
+	}{
+		{
+			"1 + 2",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBinary, code.OPERATOR_ADD),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"bake x to 1;",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpName, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturn),
+			},
+		},
+		{
+			"if (true) { 10 }; 3",
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"for (x in 3) { break; }",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIterInit),
+				code.Make(code.OpIterNext, code.ITERATE_SINGLE, 17),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpJump, 17),
+				code.Make(code.OpJump, 4),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"x += 1",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAssignGlobal, 0, code.OPERATOR_ADD+1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode := testCompile(t, tt.input)
+		testInstructions(t, tt.input, tt.expectedInstructions, bytecode.Main.Instructions)
+	}
+}
+
+func TestCompileRecipes(t *testing.T) {
+	input := `rc(a, b = 2) { bake f to rc() { a + c }; bake c to 1; f }`
+	bytecode := testCompile(t, input)
+
+	outer, ok := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledRecipe)
+	if !ok {
+		t.Fatalf("last constant is not a compiled recipe. got=%T", bytecode.Constants[len(bytecode.Constants)-1])
+	}
+
+	if outer.NumParameters != 2 || outer.NumRequired != 1 || outer.NumLocals != 4 {
+		t.Errorf("wrong recipe layout. got parameters=%d, required=%d, locals=%d",
+			outer.NumParameters, outer.NumRequired, outer.NumLocals)
+	}
+
+	testInstructions(t, input, []code.Instructions{
+		code.Make(code.OpJumpIfLocalSet, 1, 11),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpClosure, 1),
+		code.Make(code.OpName, 2),
+		code.Make(code.OpSetLocal, 2),
+		code.Make(code.OpConstant, 3),
+		code.Make(code.OpName, 4),
+		code.Make(code.OpSetLocal, 3),
+		code.Make(code.OpGetLocal, 2),
+		code.Make(code.OpReturnValue),
+	}, outer.Instructions)
+
+	// c is baked after f is created but still resolves to the local of the enclosing recipe
+	inner := bytecode.Constants[1].(*object.CompiledRecipe)
+	testInstructions(t, input, []code.Instructions{
+		code.Make(code.OpGetOuter, 1, 0),
+		code.Make(code.OpGetOuter, 1, 3),
+		code.Make(code.OpBinary, code.OPERATOR_ADD),
+		code.Make(code.OpReturnValue),
+	}, inner.Instructions)
+}
+
+func TestErrorPositions(t *testing.T) {
+	bytecode := testCompile(t, "bake a to 1;\n  a + true")
+
+	positions := bytecode.Main.Positions
+	if len(positions) != 2 {
+		t.Fatalf("wrong number of positions. want=2, got=%d", len(positions))
+	}
+
+	// OpConstant, OpName, OpSetGlobal, OpGetGlobal then OpTrue
+	binary := 3 + 3 + 3 + 3 + 1
+	if positions[binary].String() != "2:5" {
+		t.Errorf("wrong position for the binary operation. want=2:5, got=%s", positions[binary])
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"rc() {}(" + list("1", 256) + ")", "1:8: too many arguments, the limit is 255"},
+		{"[" + list("true", 65536) + "]", "program too large, jumps are limited to 65535 bytes"},
+		{"rc() { [" + list("true", 65536) + "] }", "1:1: recipe too large, jumps are limited to 65535 bytes"},
+		{strings.Repeat("rc() { "+strings.Repeat("1; ", 8000)+"}; ", 9), "program too large, it is limited to 65535 constants"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors on %.20q: %v", tt.input, p.Errors())
+		}
+		err := New().Compile(program)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %.20q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// The limits themselves are fine
+	testCompile(t, "rc() {}("+list("1", 255)+")")
+	testCompile(t, "["+list("true", 65000)+"]")
+}
+
+// list joins n copies of item with commas, eg: to write calls with many arguments
+func list(item string, n int) string {
+	return strings.TrimSuffix(strings.Repeat(item+", ", n), ", ")
+}
+
+func testCompile(t *testing.T, input string) *Bytecode {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error on %q: %s", input, err)
+	}
+
+	return compiler.Bytecode()
+}
+
+func testInstructions(t *testing.T, input string, expected []code.Instructions, actual code.Instructions) {
+	concatted := code.Instructions{}
+	for _, ins := range expected {
+		concatted = append(concatted, ins...)
+	}
+
+	if actual.String() != concatted.String() {
+		t.Errorf("wrong instructions for %q.\nwant=\n%s\ngot=\n%s", input, concatted, actual)
+	}
+}
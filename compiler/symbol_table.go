@@ -0,0 +1,80 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GLOBAL_SCOPE = "GLOBAL"
+	LOCAL_SCOPE  = "LOCAL"
+	OUTER_SCOPE  = "OUTER" // a local of an enclosing recipe, Depth recipes up
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Depth int
+}
+
+// SymbolTable maps the names of a scope to their slot, there is one global table
+// and one table per recipe being compiled
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store map[string]Symbol
+	names []string
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define returns the symbol of name in this table, giving it the next free slot if it is new
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: len(s.names), Scope: LOCAL_SCOPE}
+	if s.Outer == nil {
+		symbol.Scope = GLOBAL_SCOPE
+	}
+
+	s.store[name] = symbol
+	s.names = append(s.names, name)
+	return symbol
+}
+
+// defineSlot always gives name a new slot, parameters need one each even when their names repeat
+func (s *SymbolTable) defineSlot(name string) Symbol {
+	delete(s.store, name)
+	return s.Define(name)
+}
+
+// Resolve looks name up from this table outwards, locals of enclosing recipes become OUTER symbols
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	depth := 0
+
+	for table := s; table != nil; table = table.Outer {
+		if symbol, ok := table.store[name]; ok {
+			if symbol.Scope == LOCAL_SCOPE && depth > 0 {
+				symbol.Scope = OUTER_SCOPE
+				symbol.Depth = depth
+			}
+			return symbol, true
+		}
+		depth += 1
+	}
+
+	return Symbol{}, false
+}
+
+// Names returns the defined names in slot order
+func (s *SymbolTable) Names() []string {
+	return s.names
+}
@@ -0,0 +1,50 @@
+package diagnostic
+
+import "cottagepie/token"
+
+type Code string
+
+const (
+	// Lexer
+	UNTERMINATED_COMMENT Code = "L001"
+	ILLEGAL_CHARACTER    Code = "L002"
+	UNTERMINATED_STRING  Code = "L003"
+
+	// Parser
+	UNEXPECTED_TOKEN   Code = "P001"
+	MISSING_EXPRESSION Code = "P002"
+	INVALID_INTEGER    Code = "P003"
+	INVALID_PARAMETER  Code = "P004"
+	INVALID_FLOAT      Code = "P005"
+	OUTSIDE_LOOP       Code = "P006"
+	INVALID_ASSIGNMENT Code = "P007"
+)
+
+// Diagnostic is a problem found in the source, Start and End delimit the offending text
+type Diagnostic struct {
+	Code    Code
+	Message string
+	Start   token.Position
+	End     token.Position
+	Hint    string // optional suggestion on how to fix the problem
+}
+
+func New(code Code, tok token.Token, message string) Diagnostic {
+	return Diagnostic{Code: code, Message: message, Start: tok.Pos, End: EndOf(tok)}
+}
+
+// EndOf returns the position right after the token literal
+func EndOf(tok token.Token) token.Position {
+	end := tok.Pos
+	if end.IsValid() {
+		end.Column += len(tok.Literal)
+	}
+	return end
+}
+
+func (d Diagnostic) String() string {
+	if d.Start.IsValid() {
+		return d.Start.String() + ": " + d.Message
+	}
+	return d.Message
+}
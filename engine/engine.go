@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"cottagepie/ast"
+	"cottagepie/compiler"
+	"cottagepie/evaluator"
+	"cottagepie/object"
+	"cottagepie/vm"
+	"fmt"
+)
+
+const (
+	EVALUATOR = "eval" // the tree-walking evaluator, the reference implementation
+	VM        = "vm"   // the bytecode compiler and virtual machine
+)
+
+// Engine runs programs one after the other, later programs see what earlier ones baked
+type Engine interface {
+	Run(program *ast.Program) object.Object
+	Globals() *object.Cookbook // what the programs baked, a snapshot for the vm
+	Reset()                    // forget everything the programs baked
+}
+
+func New(name string) (Engine, error) {
+	return NewWithContext(name, object.DefaultContext())
+}
+
+// NewWithContext creates an engine whose programs do their I/O through ctx
+func NewWithContext(name string, ctx *object.Context) (Engine, error) {
+	switch name {
+	case EVALUATOR:
+		return &treeWalker{book: object.NewCookbookWithContext(ctx)}, nil
+	case VM:
+		return &bytecodeVM{symbols: compiler.NewSymbolTable(), constants: []object.Object{}, ctx: ctx}, nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want %s or %s", name, EVALUATOR, VM)
+	}
+}
+
+type treeWalker struct {
+	book *object.Cookbook
+}
+
+func (e *treeWalker) Run(program *ast.Program) object.Object {
+	return evaluator.Eval(program, e.book)
+}
+
+func (e *treeWalker) Globals() *object.Cookbook {
+	return e.book
+}
+
+func (e *treeWalker) Reset() {
+	e.book = object.NewCookbookWithContext(e.book.Context())
+}
+
+// bytecodeVM keeps the compiler state and globals between programs, like the Cookbook of the evaluator
+type bytecodeVM struct {
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+	globals   []object.Object
+	ctx       *object.Context
+}
+
+func (e *bytecodeVM) Run(program *ast.Program) object.Object {
+	comp := compiler.NewWithState(e.symbols, e.constants)
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: "Compilation failed: " + err.Error()}
+	}
+
+	bytecode := comp.Bytecode()
+	e.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobals(bytecode, e.globals)
+	machine.SetContext(e.ctx)
+	result := machine.Run()
+	e.globals = machine.Globals()
+
+	return result
+}
+
+func (e *bytecodeVM) Globals() *object.Cookbook {
+	book := object.NewCookbookWithContext(e.ctx)
+	for i, name := range e.symbols.Names() {
+		// Globals declared by a program that failed before baking them have no value yet
+		if i < len(e.globals) && e.globals[i] != nil {
+			book.Set(name, e.globals[i])
+		}
+	}
+	return book
+}
+
+func (e *bytecodeVM) Reset() {
+	e.symbols, e.constants, e.globals = compiler.NewSymbolTable(), []object.Object{}, nil
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"bytes"
+	"cottagepie/lexer"
+	"cottagepie/object"
+	"cottagepie/parser"
+	"strings"
+	"testing"
+)
+
+func TestEnginesKeepBindings(t *testing.T) {
+	inputs := []string{
+		"bake x to 40;",
+		"recipe add(a, b) { a + b }",
+		"add(x, 2)",
+	}
+
+	for _, name := range []string{EVALUATOR, VM} {
+		eng, err := New(name)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %s", name, err)
+		}
+
+		var result object.Object
+		for _, input := range inputs {
+			result = eng.Run(parser.New(lexer.New(input)).ParseProgram())
+		}
+
+		integer, ok := result.(*object.Integer)
+		if !ok || integer.Value != 42 {
+			t.Errorf("engine %s gave the wrong result. expected=42, got=%v", name, result)
+		}
+	}
+}
+
+func TestEnginesUseContext(t *testing.T) {
+	for _, name := range []string{EVALUATOR, VM} {
+		var stdout bytes.Buffer
+		eng, err := NewWithContext(name, object.NewContext(strings.NewReader("pie\n"), &stdout, &stdout))
+		if err != nil {
+			t.Fatalf("NewWithContext(%q) failed: %s", name, err)
+		}
+
+		eng.Run(parser.New(lexer.New(`bake f to rc(x) { plates(x) }; f(input("? "))`)).ParseProgram())
+
+		if stdout.String() != "? pie\n" {
+			t.Errorf("engine %s wrote the wrong output. expected=%q, got=%q", name, "? pie\n", stdout.String())
+		}
+	}
+}
+
+func TestGlobalsAndReset(t *testing.T) {
+	for _, name := range []string{EVALUATOR, VM} {
+		eng, _ := New(name)
+		eng.Run(parser.New(lexer.New(`bake b to [1]; recipe a() { 1 }; bake c to 1 / 0; bake d to 2`)).ParseProgram())
+
+		globals := eng.Globals()
+		if names := strings.Join(globals.Names(), " "); names != "a b" {
+			t.Errorf("engine %s has the wrong globals. expected=%q, got=%q", name, "a b", names)
+		}
+		if b, _ := globals.Get("b"); b == nil || b.Inspect() != "[1]" {
+			t.Errorf("engine %s has the wrong value for b, got=%v", name, b)
+		}
+
+		eng.Reset()
+		if names := eng.Globals().Names(); len(names) != 0 {
+			t.Errorf("engine %s kept globals after Reset, got=%v", name, names)
+		}
+		if result := eng.Run(parser.New(lexer.New("b")).ParseProgram()); result.Inspect() != "ERROR>> 1:1: Identifier not found: b" {
+			t.Errorf("engine %s can still use b after Reset, got=%s", name, result.Inspect())
+		}
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	if _, err := New("jit"); err == nil {
+		t.Fatalf("expected an error for an unknown engine")
+	}
+}
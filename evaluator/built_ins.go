@@ -3,11 +3,19 @@ package evaluator
 import (
 	"cottagepie/object"
 	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Longer ranges would take gigabytes of memory, or not fit in a Go slice at all
+const MAX_RANGE_LENGTH = 1 << 24
+
 var built_ins = map[string]*object.BuiltIn{
 	"length": &object.BuiltIn{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments. got=%d, want=1", len(args))
 			}
@@ -17,6 +25,8 @@ var built_ins = map[string]*object.BuiltIn{
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(arg.Len())}
 
 			default:
 				return newError("Argument to `length` not supported, got %s", args[0].Type())
@@ -24,7 +34,7 @@ var built_ins = map[string]*object.BuiltIn{
 		},
 	},
 	"first": &object.BuiltIn{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments, got=%d, want=1", len(args))
 			}
@@ -42,7 +52,7 @@ var built_ins = map[string]*object.BuiltIn{
 		},
 	},
 	"last": &object.BuiltIn{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments, got=%d, want=1", len(args))
 			}
@@ -61,7 +71,7 @@ var built_ins = map[string]*object.BuiltIn{
 		},
 	},
 	"rest": &object.BuiltIn{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("Wrong number of arguments, got=%d, want=1", len(args))
 			}
@@ -82,9 +92,9 @@ var built_ins = map[string]*object.BuiltIn{
 		},
 	},
 	"push": &object.BuiltIn{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("Wrong number of arguments, got=%d, want=1", len(args))
+				return newError("Wrong number of arguments, got=%d, want=2", len(args))
 			}
 
 			if args[0].Type() != object.ARRAY_OBJ {
@@ -93,23 +103,866 @@ var built_ins = map[string]*object.BuiltIn{
 
 			arr := args[0].(*object.Array)
 			length := len(arr.Elements)
-			if length > 0 {
-				newElements := make([]object.Object, length+1, length+1)
-				copy(newElements, arr.Elements)
-				newElements[length] = args[1]
+			newElements := make([]object.Object, length+1, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
 
-				return &object.Array{Elements: newElements}
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"keys": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			hash, err := hashArgument("keys", args)
+			if err != nil {
+				return err
+			}
+
+			elements := make([]object.Object, hash.Len())
+			for i, pair := range hash.Pairs() {
+				elements[i] = pair.Key
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"values": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			hash, err := hashArgument("values", args)
+			if err != nil {
+				return err
+			}
+
+			elements := make([]object.Object, hash.Len())
+			for i, pair := range hash.Pairs() {
+				elements[i] = pair.Value
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"items": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			hash, err := hashArgument("items", args)
+			if err != nil {
+				return err
+			}
+
+			elements := make([]object.Object, hash.Len())
+			for i, pair := range hash.Pairs() {
+				item := allocate(ctx, &object.Array{Elements: []object.Object{pair.Key, pair.Value}})
+				if isError(item) {
+					return item
+				}
+				elements[i] = item
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"has": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments, got=%d, want=2", len(args))
+			}
+
+			hash, ok := args[0].(*object.Hash)
+			if !ok {
+				return newError("First argument to `has` must be HASH, got %s", args[0].Type())
+			}
+
+			key, ok := object.HashKeyOf(args[1])
+			if !ok {
+				return newError("Unusable as hash key: %s", args[1].Type())
+			}
+
+			_, ok = hash.Get(key)
+			return nativeBoolToBooleanObject(ok)
+		},
+	},
+	"delete": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments, got=%d, want=2", len(args))
+			}
+
+			hash, ok := args[0].(*object.Hash)
+			if !ok {
+				return newError("First argument to `delete` must be HASH, got %s", args[0].Type())
+			}
+
+			key, ok := object.HashKeyOf(args[1])
+			if !ok {
+				return newError("Unusable as hash key: %s", args[1].Type())
+			}
+
+			result := copyHash(hash)
+			result.Delete(key)
+			return result
+		},
+	},
+	"merge": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return newError("Wrong number of arguments, got=0, want at least 1")
+			}
+
+			result := object.NewHash()
+			for i, arg := range args {
+				hash, ok := arg.(*object.Hash)
+				if !ok {
+					return newError("Argument %d to `merge` must be HASH, got %s", i+1, arg.Type())
+				}
+				for _, pair := range hash.Pairs() {
+					key, _ := object.HashKeyOf(pair.Key)
+					result.Set(key, pair)
+				}
+			}
+			return result
+		},
+	},
+	"slice": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments, got=%d, want=2 or 3", len(args))
+			}
+
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("First argument to `slice` must be ARRAY, got %s", args[0].Type())
+			}
+
+			length := int64(len(arr.Elements))
+			bounds := []int64{0, length}
+			for i, arg := range args[1:] {
+				bound, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("Bounds given to `slice` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = clamp(bound.Value, 0, length)
+			}
+
+			start, stop := bounds[0], bounds[1]
+			if stop < start {
+				stop = start
+			}
+			newElements := make([]object.Object, stop-start)
+			copy(newElements, arr.Elements[start:stop])
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"concat": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			newElements := []object.Object{}
+			for i, arg := range args {
+				arr, ok := arg.(*object.Array)
+				if !ok {
+					return newError("Argument %d to `concat` must be ARRAY, got %s", i+1, arg.Type())
+				}
+				newElements = append(newElements, arr.Elements...)
+			}
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"reverse": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments, got=%d, want=1", len(args))
+			}
+
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("Argument to `reverse` must be ARRAY, got %s", args[0].Type())
 			}
 
+			length := len(arr.Elements)
+			newElements := make([]object.Object, length)
+			for i, element := range arr.Elements {
+				newElements[length-1-i] = element
+			}
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"index_of": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := searchArguments("index_of", args)
+			if err != nil {
+				return err
+			}
+			return &object.Integer{Value: int64(indexOf(arr, args[1]))}
+		},
+	},
+	"contains": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := searchArguments("contains", args)
+			if err != nil {
+				return err
+			}
+			return nativeBoolToBooleanObject(indexOf(arr, args[1]) != -1)
+		},
+	},
+	"insert": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 3 {
+				return newError("Wrong number of arguments, got=%d, want=3", len(args))
+			}
+
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("First argument to `insert` must be ARRAY, got %s", args[0].Type())
+			}
+
+			length := len(arr.Elements)
+			idx, err := positionArgument("insert", args[1], length)
+			if err != nil {
+				return err
+			}
+
+			newElements := make([]object.Object, 0, length+1)
+			newElements = append(newElements, arr.Elements[:idx]...)
+			newElements = append(newElements, args[2])
+			newElements = append(newElements, arr.Elements[idx:]...)
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"remove": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("Wrong number of arguments, got=%d, want=2", len(args))
+			}
+
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("First argument to `remove` must be ARRAY, got %s", args[0].Type())
+			}
+
+			length := len(arr.Elements)
+			idx, err := positionArgument("remove", args[1], length-1)
+			if err != nil {
+				return err
+			}
+
+			newElements := make([]object.Object, 0, length-1)
+			newElements = append(newElements, arr.Elements[:idx]...)
+			newElements = append(newElements, arr.Elements[idx+1:]...)
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"range": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("Wrong number of arguments, got=%d, want=1 to 3", len(args))
+			}
+
+			values := []int64{0, 0, 1}
+			for i, arg := range args {
+				integer, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("Arguments to `range` must be INTEGER, got %s", arg.Type())
+				}
+				values[i] = integer.Value
+			}
+			// range(stop) counts from 0
+			if len(args) == 1 {
+				values[0], values[1] = 0, values[0]
+			}
+
+			start, stop, step := values[0], values[1], values[2]
+			if step == 0 {
+				return newError("Step given to `range` can't be 0")
+			}
+
+			count := rangeLength(start, stop, step)
+			if count > MAX_RANGE_LENGTH {
+				return newError("Range given to `range` is too long: more than %d elements", MAX_RANGE_LENGTH)
+			}
+			// The integers are allocated before the array holding them, check them first
+			if err := ctx.Allocate(object.OBJECT_SIZE * int64(count)); err != nil {
+				return err
+			}
+
+			elements := make([]object.Object, count)
+			for i := range elements {
+				elements[i] = &object.Integer{Value: start + int64(i)*step}
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+	"map": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("map", args)
+			if err != nil {
+				return err
+			}
+
+			newElements := make([]object.Object, len(arr.Elements))
+			for i, element := range arr.Elements {
+				result := callBack(ctx, args[1], element)
+				if isError(result) {
+					return result
+				}
+				newElements[i] = result
+			}
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"filter": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("filter", args)
+			if err != nil {
+				return err
+			}
+
+			newElements := []object.Object{}
+			for _, element := range arr.Elements {
+				result := callBack(ctx, args[1], element)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					newElements = append(newElements, element)
+				}
+			}
+			return &object.Array{Elements: newElements}
+		},
+	},
+	"reduce": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("Wrong number of arguments, got=%d, want=2 or 3", len(args))
+			}
+
+			arr, err := callbackArguments("reduce", args[:2])
+			if err != nil {
+				return err
+			}
+
+			elements := arr.Elements
+			var accumulator object.Object
+			if len(args) == 3 {
+				accumulator = args[2]
+			} else if len(elements) > 0 {
+				accumulator, elements = elements[0], elements[1:]
+			} else {
+				return newError("Cannot `reduce` an empty array without an initial value")
+			}
+
+			for _, element := range elements {
+				accumulator = callBack(ctx, args[1], accumulator, element)
+				if isError(accumulator) {
+					return accumulator
+				}
+			}
+			return accumulator
+		},
+	},
+	"each": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("each", args)
+			if err != nil {
+				return err
+			}
+
+			for _, element := range arr.Elements {
+				if result := callBack(ctx, args[1], element); isError(result) {
+					return result
+				}
+			}
 			return NULL
 		},
 	},
+	"any": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("any", args)
+			if err != nil {
+				return err
+			}
+
+			for _, element := range arr.Elements {
+				result := callBack(ctx, args[1], element)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					return TRUE
+				}
+			}
+			return FALSE
+		},
+	},
+	"all": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("all", args)
+			if err != nil {
+				return err
+			}
+
+			for _, element := range arr.Elements {
+				result := callBack(ctx, args[1], element)
+				if isError(result) {
+					return result
+				}
+				if !isTruthy(result) {
+					return FALSE
+				}
+			}
+			return TRUE
+		},
+	},
+	"sort": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments, got=%d, want=1 or 2", len(args))
+			}
+
+			arr, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("First argument to `sort` must be ARRAY, got %s", args[0].Type())
+			}
+
+			if len(args) == 1 {
+				return sortElements(arr.Elements, arr.Elements, compareValues)
+			}
+
+			if !isCallable(args[1]) {
+				return newError("Second argument to `sort` must be RECIPE, got %s", args[1].Type())
+			}
+			return sortElements(arr.Elements, arr.Elements, func(a, b object.Object) (int, *object.Error) {
+				return compareWith(ctx, args[1], a, b)
+			})
+		},
+	},
+	"sort_by": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("sort_by", args)
+			if err != nil {
+				return err
+			}
+
+			// Each key is computed once, then the elements are sorted along with their keys
+			keys := make([]object.Object, len(arr.Elements))
+			for i, element := range arr.Elements {
+				keys[i] = callBack(ctx, args[1], element)
+				if isError(keys[i]) {
+					return keys[i]
+				}
+			}
+
+			return sortElements(arr.Elements, keys, compareValues)
+		},
+	},
+	"group_by": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			arr, err := callbackArguments("group_by", args)
+			if err != nil {
+				return err
+			}
+
+			groups := object.NewHash()
+			for _, element := range arr.Elements {
+				group := callBack(ctx, args[1], element)
+				if isError(group) {
+					return group
+				}
+
+				key, ok := object.HashKeyOf(group)
+				if !ok {
+					return newError("Unusable as hash key: %s", group.Type())
+				}
+
+				pair, ok := groups.Get(key)
+				if !ok {
+					pair = object.HashPair{Key: object.FreezeKey(group), Value: &object.Array{}}
+				}
+				members := pair.Value.(*object.Array)
+				members.Elements = append(members.Elements, element)
+				groups.Set(key, pair)
+			}
+			return groups
+		},
+	},
+	"zip": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return newError("Wrong number of arguments, got=0, want at least 1")
+			}
+
+			length := -1
+			for i, arg := range args {
+				arr, ok := arg.(*object.Array)
+				if !ok {
+					return newError("Argument %d to `zip` must be ARRAY, got %s", i+1, arg.Type())
+				}
+				if length == -1 || len(arr.Elements) < length {
+					length = len(arr.Elements)
+				}
+			}
+
+			newElements := make([]object.Object, length)
+			for i := range newElements {
+				tuple := make([]object.Object, len(args))
+				for j, arg := range args {
+					tuple[j] = arg.(*object.Array).Elements[i]
+				}
+				if newElements[i] = allocate(ctx, &object.Array{Elements: tuple}); isError(newElements[i]) {
+					return newElements[i]
+				}
+			}
+			return &object.Array{Elements: newElements}
+		},
+	},
 	"plates": &object.BuiltIn{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(ctx.Stdout, arg.Inspect())
+			}
+			return NULL
+		},
+	},
+	"platef": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) == 0 {
+				return newError("Wrong number of arguments, got=0, want at least 1")
+			}
+
+			format, ok := args[0].(*object.String)
+			if !ok {
+				return newError("First argument to `platef` must be STRING, got %s", args[0].Type())
 			}
+
+			values := make([]interface{}, len(args)-1)
+			for i, arg := range args[1:] {
+				values[i] = formatValue(arg)
+			}
+			// Strings have no escape sequences, so like plates the output ends the line
+			fmt.Fprintf(ctx.Stdout, format.Value+"\n", values...)
 			return NULL
 		},
 	},
+	"input": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) > 1 {
+				return newError("Wrong number of arguments, got=%d, want=0 or 1", len(args))
+			}
+
+			if len(args) == 1 {
+				prompt, ok := args[0].(*object.String)
+				if !ok {
+					return newError("Argument to `input` must be STRING, got %s", args[0].Type())
+				}
+				io.WriteString(ctx.Stdout, prompt.Value)
+			}
+
+			line, err := ctx.ReadLine()
+			if err == io.EOF {
+				return NULL
+			}
+			if err != nil {
+				return newError("Could not read input: %s", err)
+			}
+			return &object.String{Value: line}
+		},
+	},
+	"int": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments, got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer:
+				return arg
+			case *object.Float:
+				return floatToInteger("int", math.Trunc(arg.Value))
+			case *object.String:
+				value, err := strconv.ParseInt(arg.Value, 10, 64)
+				if err != nil {
+					return newError("Could not convert %q to INTEGER", arg.Value)
+				}
+				return &object.Integer{Value: value}
+
+			default:
+				return newError("Argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	"float": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments, got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer:
+				return &object.Float{Value: float64(arg.Value)}
+			case *object.Float:
+				return arg
+			case *object.String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return newError("Could not convert %q to FLOAT", arg.Value)
+				}
+				return &object.Float{Value: value}
+
+			default:
+				return newError("Argument to `float` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	"round": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("Wrong number of arguments, got=%d, want=1 or 2", len(args))
+			}
+
+			if !isNumber(args[0]) {
+				return newError("Argument to `round` must be INTEGER or FLOAT, got %s", args[0].Type())
+			}
+
+			if integer, ok := args[0].(*object.Integer); ok && len(args) == 1 {
+				return integer
+			}
+
+			if len(args) == 1 {
+				return floatToInteger("round", math.Round(toFloat(args[0])))
+			}
+
+			digits, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("Digits given to `round` must be INTEGER, got %s", args[1].Type())
+			}
+
+			scale := math.Pow(10, float64(digits.Value))
+			return &object.Float{Value: math.Round(toFloat(args[0])*scale) / scale}
+		},
+	},
+	"floor": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments, got=%d, want=1", len(args))
+			}
+
+			if !isNumber(args[0]) {
+				return newError("Argument to `floor` must be INTEGER or FLOAT, got %s", args[0].Type())
+			}
+
+			if integer, ok := args[0].(*object.Integer); ok {
+				return integer
+			}
+
+			return floatToInteger("floor", math.Floor(toFloat(args[0])))
+		},
+	},
+	"ceil": &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments, got=%d, want=1", len(args))
+			}
+
+			if !isNumber(args[0]) {
+				return newError("Argument to `ceil` must be INTEGER or FLOAT, got %s", args[0].Type())
+			}
+
+			if integer, ok := args[0].(*object.Integer); ok {
+				return integer
+			}
+
+			return floatToInteger("ceil", math.Ceil(toFloat(args[0])))
+		},
+	},
+}
+
+// formatValue gives the Go value matching an object so platef verbs like %d and %.2f work on it
+func formatValue(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	default:
+		return obj.Inspect()
+	}
+}
+
+// floatToInteger converts an already integral float, erroring when it doesn't fit in an INTEGER
+func floatToInteger(name string, value float64) object.Object {
+	if math.IsNaN(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+		return newError("Result of `%s` is out of the INTEGER range: %g", name, value)
+	}
+	return &object.Integer{Value: int64(value)}
+}
+
+// hashArgument checks that a built-in taking a single hash was given one
+func hashArgument(name string, args []object.Object) (*object.Hash, *object.Error) {
+	if len(args) != 1 {
+		return nil, newError("Wrong number of arguments, got=%d, want=1", len(args))
+	}
+
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return nil, newError("Argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	return hash, nil
+}
+
+// searchArguments checks the array and value given to index_of and contains
+func searchArguments(name string, args []object.Object) (*object.Array, *object.Error) {
+	if len(args) != 2 {
+		return nil, newError("Wrong number of arguments, got=%d, want=2", len(args))
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, newError("First argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	return arr, nil
+}
+
+// positionArgument checks an index between 0 and max given to insert or remove
+func positionArgument(name string, arg object.Object, max int) (int, *object.Error) {
+	idx, ok := arg.(*object.Integer)
+	if !ok {
+		return 0, newError("Index given to `%s` must be INTEGER, got %s", name, arg.Type())
+	}
+	if idx.Value < 0 || idx.Value > int64(max) {
+		return 0, newError("Index given to `%s` is out of range: %d", name, idx.Value)
+	}
+	return int(idx.Value), nil
+}
+
+// indexOf gives the position of the first element equal to value, -1 when there is none
+func indexOf(arr *object.Array, value object.Object) int {
+	for i, element := range arr.Elements {
+		if element.Equal(value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyHash(hash *object.Hash) *object.Hash {
+	result := object.NewHash()
+	for _, pair := range hash.Pairs() {
+		key, _ := object.HashKeyOf(pair.Key)
+		result.Set(key, pair)
+	}
+	return result
+}
+
+func clamp(value, min, max int64) int64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// rangeLength counts the values from start up to stop (excluded) going by step. The distance
+// is computed on unsigned integers, it can't overflow there
+func rangeLength(start, stop, step int64) uint64 {
+	switch {
+	case step > 0 && start < stop:
+		return (uint64(stop)-uint64(start)-1)/uint64(step) + 1
+	case step < 0 && start > stop:
+		return (uint64(start)-uint64(stop)-1)/uint64(-step) + 1
+	default:
+		return 0
+	}
+}
+
+// callbackArguments checks the array and recipe given to the built-ins calling a recipe on each element
+func callbackArguments(name string, args []object.Object) (*object.Array, *object.Error) {
+	if len(args) != 2 {
+		return nil, newError("Wrong number of arguments, got=%d, want=2", len(args))
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, newError("First argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	if !isCallable(args[1]) {
+		return nil, newError("Second argument to `%s` must be RECIPE, got %s", name, args[1].Type())
+	}
+	return arr, nil
+}
+
+func isCallable(obj object.Object) bool {
+	return obj.Type() == object.RECIPE_OBJ || obj.Type() == object.BUILT_IN_OBJ
+}
+
+// sortElements sorts a copy of elements by their keys, keeping elements with equal keys in
+// their order. The first error of compare stops the sort
+func sortElements(elements, keys []object.Object, compare func(a, b object.Object) (int, *object.Error)) object.Object {
+	order := make([]int, len(elements))
+	for i := range order {
+		order[i] = i
+	}
+
+	var failed *object.Error
+	sort.SliceStable(order, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		result, err := compare(keys[order[i]], keys[order[j]])
+		if err != nil {
+			failed = err
+		}
+		return result < 0
+	})
+
+	if failed != nil {
+		return failed
+	}
+
+	newElements := make([]object.Object, len(elements))
+	for i, idx := range order {
+		newElements[i] = elements[idx]
+	}
+	return &object.Array{Elements: newElements}
+}
+
+// compareValues orders numbers by value and strings alphabetically
+func compareValues(a, b object.Object) (int, *object.Error) {
+	switch {
+	case a.Type() == object.INTEGER_OBJ && b.Type() == object.INTEGER_OBJ:
+		x, y := a.(*object.Integer).Value, b.(*object.Integer).Value
+		return compareOrdered(x < y, x > y), nil
+	case isNumber(a) && isNumber(b):
+		x, y := toFloat(a), toFloat(b)
+		return compareOrdered(x < y, x > y), nil
+	case a.Type() == object.STRING_OBJ && b.Type() == object.STRING_OBJ:
+		return strings.Compare(a.(*object.String).Value, b.(*object.String).Value), nil
+	default:
+		return 0, newError("Cannot compare %s and %s", a.Type(), b.Type())
+	}
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareWith asks a comparator recipe for the order of a and b, it serves either a
+// boolean (true when a goes first) or a number (negative when a goes first)
+func compareWith(ctx *object.Context, comparator, a, b object.Object) (int, *object.Error) {
+	result := callBack(ctx, comparator, a, b)
+
+	switch result := result.(type) {
+	case *object.Error:
+		return 0, result
+	case *object.Boolean:
+		return compareOrdered(result.Value, false), nil
+	case *object.Integer, *object.Float:
+		return compareOrdered(toFloat(result) < 0, toFloat(result) > 0), nil
+	default:
+		return 0, newError("Comparator given to `sort` must serve BOOLEAN or a number, got %s", result.Type())
+	}
 }
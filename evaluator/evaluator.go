@@ -1,18 +1,47 @@
 package evaluator
 
 import (
+	"context"
 	"cottagepie/ast"
 	"cottagepie/object"
 	"fmt"
+	"math"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, book *object.Cookbook) object.Object {
+	var result object.Object
+	if err := book.Context().Step(); err != nil {
+		result = err
+	} else {
+		result = evalNode(node, book)
+	}
+
+	// The innermost node that produced an error is the most precise location we have
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+// EvalContext evaluates node like Eval, stopping with an error object once ctx is cancelled
+// or the evaluation goes over one of the limits
+func EvalContext(ctx context.Context, node ast.Node, book *object.Cookbook, limits object.Limits) object.Object {
+	end := book.Context().Begin(ctx, limits)
+	defer end()
+
+	return Eval(node, book)
+}
+
+func evalNode(node ast.Node, book *object.Cookbook) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -26,19 +55,37 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 		if isError(val) {
 			return val
 		}
+		if recipe, ok := val.(*object.Recipe); ok && recipe.Name == "" {
+			recipe.Name = node.Name.Value
+		}
 		book.Set(node.Name.Value, val)
 
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, book)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, book)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
+	case *ast.RecipeDeclaration:
+		recipe := evalRecipeLiteral(node.Recipe, book)
+		recipe.Name = node.Name.Value
+		book.Set(node.Name.Value, recipe)
+
 	// Expressions
 	case *ast.Identifier:
 		return evalIdentifier(node, book)
 
 	case *ast.RecipeLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Recipe{Parameters: params, Body: body, Cookbook: book}
+		return allocate(book.Context(), evalRecipeLiteral(node, book))
 
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocate(book.Context(), &object.String{Value: node.Value})
 
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, book)
@@ -48,7 +95,7 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return allocate(book.Context(), evalPrefixExpression(node.Operator, right))
 
 	case *ast.InfixExpression:
 		left := Eval(node.Left, book)
@@ -59,7 +106,7 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocate(book.Context(), evalInfixExpression(node.Operator, left, right))
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, book)
@@ -72,6 +119,9 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 		}
 		return evalIndexExpression(left, index)
 
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, book)
+
 	case *ast.IfExpression:
 		return evalIfExpression(node, book)
 
@@ -81,6 +131,9 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
@@ -100,14 +153,18 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyRecipe(recipe, args)
+		result := applyRecipe(book.Context(), recipe, args)
+		if err, ok := result.(*object.Error); ok {
+			addTraceFrame(err, recipe, node)
+		}
+		return result
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, book)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(book.Context(), &object.Array{Elements: elements})
 	}
 
 	return nil
@@ -116,7 +173,13 @@ func Eval(node ast.Node, book *object.Cookbook) object.Object {
 func evalProgram(program *ast.Program, book *object.Cookbook) object.Object {
 	var result object.Object
 
+	hoistRecipeDeclarations(program.Statements, book)
+
 	for _, statement := range program.Statements {
+		if _, ok := statement.(*ast.RecipeDeclaration); ok {
+			continue
+		}
+
 		result = Eval(statement, book)
 
 		switch result := result.(type) {
@@ -124,12 +187,45 @@ func evalProgram(program *ast.Program, book *object.Cookbook) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return newError("%s outside of a loop", result.Inspect())
 		}
 	}
 
 	return result
 }
 
+// hoistRecipeDeclarations binds every recipe declared in a list of statements before
+// any of them runs, so declarations can call each other whatever their order
+func hoistRecipeDeclarations(statements []ast.Statement, book *object.Cookbook) {
+	for _, statement := range statements {
+		if declaration, ok := statement.(*ast.RecipeDeclaration); ok {
+			Eval(declaration, book)
+		}
+	}
+}
+
+func evalRecipeLiteral(node *ast.RecipeLiteral, book *object.Cookbook) *object.Recipe {
+	return &object.Recipe{
+		Parameters: node.Parameters,
+		Defaults:   node.Defaults,
+		Rest:       node.Rest,
+		Body:       node.Body,
+		Cookbook:   book,
+	}
+}
+
+// allocate accounts for a newly created object, failing when the run goes over its memory limit
+func allocate(ctx *object.Context, obj object.Object) object.Object {
+	if obj == nil || isError(obj) {
+		return obj
+	}
+	if err := ctx.Allocate(object.SizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -175,12 +271,18 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 func evalBlockStatement(block *ast.BlockStatement, book *object.Cookbook) object.Object {
 	var result object.Object
 
+	hoistRecipeDeclarations(block.Statements, book)
+
 	for _, statement := range block.Statements {
+		if _, ok := statement.(*ast.RecipeDeclaration); ok {
+			continue
+		}
+
 		result = Eval(statement, book)
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.SERVES_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.SERVES_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -190,11 +292,18 @@ func evalBlockStatement(block *ast.BlockStatement, book *object.Cookbook) object
 }
 
 func evalMinusOperatorExpression(right object.Object) object.Object {
+	if float, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -float.Value}
+	}
+
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("Unknown operator: -%s", right.Type())
 	}
 
 	value := right.(*object.Integer).Value
+	if value == math.MinInt64 {
+		return newError("Integer overflow: -(%d)", value)
+	}
 	return &object.Integer{Value: -value}
 }
 
@@ -202,12 +311,14 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(left.Equal(right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!left.Equal(right))
 	case left.Type() != right.Type():
 		return newError("Type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -219,15 +330,100 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	switch operator {
+	case "+", "-", "*", "/", "%":
+		return evalIntegerArithmetic(operator, leftVal, rightVal)
+
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalIntegerArithmetic returns an error instead of wrapping around on int64 overflow
+// or panicking on a division by zero
+func evalIntegerArithmetic(operator string, leftVal, rightVal int64) object.Object {
+	var result int64
+	overflow := false
+
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		result = leftVal + rightVal
+		overflow = (rightVal > 0 && result < leftVal) || (rightVal < 0 && result > leftVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		result = leftVal - rightVal
+		overflow = (rightVal > 0 && result > leftVal) || (rightVal < 0 && result < leftVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		result = leftVal * rightVal
+		overflow = leftVal != 0 && (result/leftVal != rightVal || (leftVal == -1 && rightVal == math.MinInt64))
+	case "/", "%":
+		if rightVal == 0 {
+			return newError("Division by zero: %d %s 0", leftVal, operator)
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			overflow = operator == "/"
+		}
+		if operator == "/" {
+			result = leftVal / rightVal
+		} else {
+			result = leftVal % rightVal
+		}
+	}
+
+	if overflow {
+		return newError("Integer overflow: %d %s %d", leftVal, operator, rightVal)
+	}
+	return integerObject(result)
+}
+
+// Integers are immutable, so the results of arithmetic on small values can share preallocated objects
+const (
+	MIN_CACHED_INTEGER = -128
+	MAX_CACHED_INTEGER = 1023
+)
+
+var cachedIntegers = func() []object.Integer {
+	integers := make([]object.Integer, MAX_CACHED_INTEGER-MIN_CACHED_INTEGER+1)
+	for i := range integers {
+		integers[i].Value = int64(i + MIN_CACHED_INTEGER)
+	}
+	return integers
+}()
+
+func integerObject(value int64) *object.Integer {
+	if value >= MIN_CACHED_INTEGER && value <= MAX_CACHED_INTEGER {
+		return &cachedIntegers[value-MIN_CACHED_INTEGER]
+	}
+	return &object.Integer{Value: value}
+}
+
+// evalFloatInfixExpression handles floats and mixed integer/float operands, integers are promoted to floats
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/", "%":
+		if rightVal == 0 {
+			return newError("Division by zero: %s %s %s", left.Inspect(), operator, right.Inspect())
+		}
+		if operator == "/" {
+			return &object.Float{Value: leftVal / rightVal}
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -242,14 +438,35 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
 	}
+}
 
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIfExpression(ie *ast.IfExpression, book *object.Cookbook) object.Object {
@@ -259,14 +476,133 @@ func evalIfExpression(ie *ast.IfExpression, book *object.Cookbook) object.Object
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, book)
+		return orNull(Eval(ie.Consequence, book))
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, book)
+		return orNull(Eval(ie.Alternative, book))
 	} else {
 		return NULL
 	}
 }
 
+// orNull turns the missing value of a block ending with a statement like bake into null
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return NULL
+	}
+	return obj
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, book *object.Cookbook) object.Object {
+	for {
+		condition := Eval(ws.Condition, book)
+		if isError(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if done, result := evalLoopBody(ws.Body, book); done {
+			return result
+		}
+	}
+}
+
+// evalForStatement binds the loop names in the current book, with one name it gets the
+// array element, string character, hash key or integer from 0 to n - 1. With two names
+// the first one gets the index (or hash key) and the second one the element (or hash value)
+func evalForStatement(fs *ast.ForStatement, book *object.Cookbook) object.Object {
+	iterable := Eval(fs.Iterable, book)
+	if isError(iterable) {
+		return iterable
+	}
+
+	var result object.Object = NULL
+	_, isHash := iterable.(*object.Hash)
+
+	err := iterate(iterable, func(key, value object.Object) bool {
+		if fs.Value != nil {
+			book.Set(fs.Key.Value, key)
+			book.Set(fs.Value.Value, value)
+		} else if isHash {
+			book.Set(fs.Key.Value, key)
+		} else {
+			book.Set(fs.Key.Value, value)
+		}
+
+		done, bodyResult := evalLoopBody(fs.Body, book)
+		if done {
+			result = bodyResult
+		}
+		return !done
+	})
+	if err != nil {
+		return err
+	}
+
+	return result
+}
+
+// iterate calls fn with each (index, element) of an array or string, each (key, value)
+// of a hash or each (i, i) from 0 to n - 1 for an integer n, until fn returns false
+func iterate(iterable object.Object, fn func(key, value object.Object) bool) *object.Error {
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		elements := iterable.Elements
+		for i, element := range elements {
+			if !fn(&object.Integer{Value: int64(i)}, element) {
+				break
+			}
+		}
+
+	case *object.String:
+		i := 0
+		for _, char := range iterable.Value {
+			if !fn(&object.Integer{Value: int64(i)}, &object.String{Value: string(char)}) {
+				break
+			}
+			i += 1
+		}
+
+	case *object.Hash:
+		// The body may add pairs, the loop goes through the ones the hash had when it started
+		pairs := append([]object.HashPair{}, iterable.Pairs()...)
+		for _, pair := range pairs {
+			if !fn(pair.Key, pair.Value) {
+				break
+			}
+		}
+
+	case *object.Integer:
+		for i := int64(0); i < iterable.Value; i++ {
+			index := &object.Integer{Value: i}
+			if !fn(index, index) {
+				break
+			}
+		}
+
+	default:
+		return newError("Cannot iterate over %s", iterable.Type())
+	}
+
+	return nil
+}
+
+// evalLoopBody runs one iteration, done is true when the loop has to stop with the given result
+func evalLoopBody(body *ast.BlockStatement, book *object.Cookbook) (bool, object.Object) {
+	result := Eval(body, book)
+
+	switch result := result.(type) {
+	case *object.Break:
+		return true, NULL
+	case *object.ServesValue, *object.Error:
+		return true, result
+	}
+
+	return false, nil
+}
+
 func evalIdentifier(node *ast.Identifier, book *object.Cookbook) object.Object {
 	if val, ok := book.Get(node.Value); ok {
 		return val
@@ -318,29 +654,141 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func applyRecipe(rc object.Object, args []object.Object) object.Object {
+func applyRecipe(ctx *object.Context, rc object.Object, args []object.Object) object.Object {
 	switch recipe := rc.(type) {
 	case *object.Recipe:
-		extendedBook := extendRecipeBook(recipe, args)
+		if err := checkArity(recipe, len(args)); err != nil {
+			return err
+		}
+
+		if err := ctx.Enter(); err != nil {
+			return err
+		}
+		defer ctx.Leave()
+
+		extendedBook, err := extendRecipeBook(recipe, args)
+		if err != nil {
+			return err
+		}
+
 		evaluated := Eval(recipe.Body, extendedBook)
-		return unwrapServesValue(evaluated)
+		if evaluated == BREAK || evaluated == CONTINUE {
+			return newError("%s outside of a loop", evaluated.Inspect())
+		}
+		return unwrapServesValue(orNull(evaluated))
 
 	case *object.BuiltIn:
-		return recipe.Fn(args...)
+		return allocate(ctx, recipe.Fn(ctx, args...))
 
 	default:
 		return newError("Not a function: %s", rc.Type())
 	}
 }
 
-func extendRecipeBook(rc *object.Recipe, args []object.Object) *object.Cookbook {
+func checkArity(rc *object.Recipe, count int) *object.Error {
+	min, max := rc.Arity()
+	return CheckArity(rc.Name, min, max, count)
+}
+
+// CheckArity returns an error when count is outside of [min, max], max being -1 for variadic recipes
+func CheckArity(recipeName string, min, max, count int) *object.Error {
+	if count >= min && (max == -1 || count <= max) {
+		return nil
+	}
+
+	var want string
+	switch {
+	case max == -1:
+		want = fmt.Sprintf("at least %d", min)
+	case min == max:
+		want = fmt.Sprintf("%d", min)
+	default:
+		want = fmt.Sprintf("%d to %d", min, max)
+	}
+
+	name := "recipe"
+	if recipeName != "" {
+		name = "`" + recipeName + "`"
+	}
+
+	return newError("Wrong number of arguments to %s, got=%d, want=%s", name, count, want)
+}
+
+// extendRecipeBook binds the arguments to the recipe parameters, missing arguments
+// take their default value which is evaluated in the new book so it can use earlier parameters
+func extendRecipeBook(rc *object.Recipe, args []object.Object) (*object.Cookbook, *object.Error) {
 	book := object.NewExtendedCookbook(rc.Cookbook)
+	if err := book.Context().Allocate(object.OBJECT_SIZE + object.PAIR_SIZE*int64(len(rc.Parameters))); err != nil {
+		return nil, err
+	}
 
 	for param_idx, param := range rc.Parameters {
-		book.Set(param.Value, args[param_idx])
+		if param_idx < len(args) {
+			book.Set(param.Value, args[param_idx])
+			continue
+		}
+
+		value := Eval(rc.Defaults[param_idx], book)
+		if err, ok := value.(*object.Error); ok {
+			return nil, err
+		}
+		book.Set(param.Value, value)
+	}
+
+	if rc.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(rc.Parameters) {
+			rest = append(rest, args[len(rc.Parameters):]...)
+		}
+		restArray := &object.Array{Elements: rest}
+		if err := book.Context().Allocate(object.SizeOf(restArray)); err != nil {
+			return nil, err
+		}
+		book.Set(rc.Rest.Value, restArray)
 	}
 
-	return book
+	return book, nil
+}
+
+// addTraceFrame records the recipe call an error is unwinding through, built-ins
+// don't get a frame as the error position already points at their call. Errors
+// without a position were raised by the call itself (eg: wrong arity) and belong to the caller
+func addTraceFrame(err *object.Error, rc object.Object, call *ast.CallExpression) {
+	// A recipe called back by a built-in was called from where the built-in was, see callBack
+	if _, ok := rc.(*object.BuiltIn); ok && len(err.Trace) > 0 && !err.Trace[len(err.Trace)-1].CallSite.IsValid() {
+		err.Trace[len(err.Trace)-1].CallSite = call.Pos()
+	}
+
+	recipe, ok := rc.(*object.Recipe)
+	if !ok || !err.Pos.IsValid() {
+		return
+	}
+
+	err.Trace = append(err.Trace, object.Frame{Name: recipe.Name, CallSite: call.Pos()})
+}
+
+// applyCallBack is applyRecipe, built-ins referring to it directly would make built_ins part of its own initialization
+var applyCallBack func(ctx *object.Context, rc object.Object, args []object.Object) object.Object
+
+func init() {
+	applyCallBack = applyRecipe
+}
+
+// callBack calls a recipe given to a built-in, through the caller of the engine running
+// the program if it installed one. Built-ins don't know where they were called from, the
+// frame they add to errors gets its call site once the error reaches their call
+func callBack(ctx *object.Context, rc object.Object, args ...object.Object) object.Object {
+	if caller := ctx.Caller(); caller != nil {
+		return caller(rc, args)
+	}
+
+	result := applyCallBack(ctx, rc, args)
+	if err, ok := result.(*object.Error); ok && err.Pos.IsValid() {
+		if recipe, ok := rc.(*object.Recipe); ok {
+			err.Trace = append(err.Trace, object.Frame{Name: recipe.Name})
+		}
+	}
+	return result
 }
 
 func unwrapServesValue(obj object.Object) object.Object {
@@ -361,41 +809,135 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// evalAssignExpression updates an existing binding in the scope defining it, or an
+// array element / hash value in place. Compound assignments apply their operator first
+func evalAssignExpression(node *ast.AssignExpression, book *object.Cookbook) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		value := Eval(node.Value, book)
+		if isError(value) {
+			return value
+		}
+
+		if node.Operator != "" {
+			current := evalIdentifier(target, book)
+			if isError(current) {
+				return current
+			}
+
+			value = allocate(book.Context(), evalInfixExpression(node.Operator, current, value))
+			if isError(value) {
+				return value
+			}
+		}
+
+		if _, ok := book.Assign(target.Value, value); !ok {
+			return newError("Cannot assign to undefined name: %s", target.Value)
+		}
+		return value
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, book)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, book)
+		if isError(index) {
+			return index
+		}
+		value := Eval(node.Value, book)
+		if isError(value) {
+			return value
+		}
+
+		if node.Operator != "" {
+			current := evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+
+			value = allocate(book.Context(), evalInfixExpression(node.Operator, current, value))
+			if isError(value) {
+				return value
+			}
+		}
+
+		if left.Type() == object.HASH_OBJ {
+			if err := book.Context().Allocate(object.PAIR_SIZE); err != nil {
+				return err
+			}
+		}
+		return evalIndexAssignment(left, index, value)
+
+	default:
+		return newError("Cannot assign to %s", node.Target.String())
+	}
+}
+
+func evalIndexAssignment(left, index, value object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		idx := index.(*object.Integer).Value
+
+		if idx < 0 || idx >= int64(len(array.Elements)) {
+			return newError("Index out of range: %d, length is %d", idx, len(array.Elements))
+		}
+		if array.Frozen {
+			return newError("Cannot assign to a frozen array: hash keys can't be changed")
+		}
+		array.Elements[idx] = value
+
+	case left.Type() == object.HASH_OBJ:
+		hash := left.(*object.Hash)
+
+		key, ok := object.HashKeyOf(index)
+		if !ok {
+			return newError("Unusable as hash key: %s", index.Type())
+		}
+		hash.Set(key, object.HashPair{Key: object.FreezeKey(index), Value: value})
+
+	default:
+		return newError("Index assignment not supported: %s", left.Type())
+	}
+
+	return value
+}
+
 func evalHashLiteral(node *ast.HashLiteral, book *object.Cookbook) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, book)
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, book)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashed, ok := object.HashKeyOf(key)
 		if !ok {
 			return newError("Unusable as a hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, book)
+		value := Eval(pair.Value, book)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashed, object.HashPair{Key: object.FreezeKey(key), Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return allocate(book.Context(), hash)
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.HashKeyOf(index)
 	if !ok {
 		return newError("Unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
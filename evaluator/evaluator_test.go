@@ -1,10 +1,15 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
 	"cottagepie/lexer"
 	"cottagepie/object"
 	"cottagepie/parser"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestErrorHandling(t *testing.T) {
@@ -79,6 +84,93 @@ func TestErrorHandling(t *testing.T) {
 
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedInspect string
+	}{
+		{
+			"5 + true;",
+			"ERROR>> 1:3: Type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"bake a to 1;\n  foobar;",
+			"ERROR>> 2:3: Identifier not found: foobar",
+		},
+		{
+			"bake add to rc(a, b) {\n  a - b;\n};\nadd(\"x\", \"y\");",
+			"ERROR>> 2:5: Unknown operator: STRING - STRING",
+		},
+		{
+			"length(1)",
+			"ERROR>> 1:7: Argument to `length` not supported, got INTEGER",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object served. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expectedInspect {
+			t.Errorf("Wrong error. Expected=%q, got=%q", tt.expectedInspect, errObj.Inspect())
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	input := `bake add to rc(a, b) {
+  a + b;
+};
+bake twice to rc(x) {
+  add(x, x);
+};
+bake run to rc() { twice(true) };
+run();`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object served. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := "\tat add (2:5)\n" +
+		"\tat twice (5:6)\n" +
+		"\tat run (7:25)\n" +
+		"\tat <main> (8:4)\n"
+
+	if errObj.StackTrace() != expected {
+		t.Errorf("Wrong stack trace. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+
+	anonymous := testEval("rc(x) { -x }(true)")
+	errObj, ok = anonymous.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object served. got=%T (%+v)", anonymous, anonymous)
+	}
+
+	expected = "\tat <anonymous> (1:9)\n\tat <main> (1:13)\n"
+	if errObj.StackTrace() != expected {
+		t.Errorf("Wrong stack trace. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+
+	calledBack := testEval("recipe half(x) { x / 0 }\nrecipe halves(xs) { map(xs, half) }\nhalves([1])")
+	errObj, ok = calledBack.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object served. got=%T (%+v)", calledBack, calledBack)
+	}
+
+	expected = "\tat half (1:20)\n\tat halves (2:24)\n\tat <main> (3:7)\n"
+	if errObj.StackTrace() != expected {
+		t.Errorf("Wrong stack trace of a recipe called back. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+}
+
 func TestBuiltInRecipes(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -101,6 +193,7 @@ func TestBuiltInRecipes(t *testing.T) {
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
+		{`push([1], 2)`, []int{1, 2}},
 		{`push(1, 1)`, "Argument to `push` must be ARRAY, got INTEGER"},
 	}
 
@@ -111,6 +204,22 @@ func TestBuiltInRecipes(t *testing.T) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
 
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("Object is not an Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if len(array.Elements) != len(expected) {
+				t.Errorf("Wrong number of elements. expected=%d, got=%d", len(expected), len(array.Elements))
+				continue
+			}
+
+			for i, expectedElement := range expected {
+				testIntegerObject(t, array.Elements[i], int64(expectedElement))
+			}
+
 		case string:
 			errObj, ok := evaluated.(*object.Error)
 			if !ok {
@@ -124,6 +233,147 @@ func TestBuiltInRecipes(t *testing.T) {
 	}
 }
 
+func TestCollectionBuiltIns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`length({"a": 1, "b": 2})`, "2"},
+		{`keys({"b": 1, "a": 2})`, "[b, a]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`items({"b": 1, [0]: 2})`, "[[b, 1], [[0], 2]]"},
+		{`keys({})`, "[]"},
+		{`keys([1])`, "ERROR>> 1:5: Argument to `keys` must be HASH, got ARRAY"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`has({[1, 2]: 1}, [1, 2])`, "true"},
+		{`has({}, {})`, "ERROR>> 1:4: Unusable as hash key: HASH"},
+		{`bake h to {"a": 1, "b": 2, "c": 3}; [delete(h, "b"), h]`, "[{a: 1, c: 3}, {a: 1, b: 2, c: 3}]"},
+		{`bake h to delete({"a": 1, "b": 2, "c": 3}, "a"); h["d"] = 4; h`, "{b: 2, c: 3, d: 4}"},
+		{`delete({"a": 1}, "z")`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, "{a: 1, b: 3, c: 4}"},
+		{`merge({"a": 1})`, "{a: 1}"},
+		{`merge({}, 1)`, "ERROR>> 1:6: Argument 2 to `merge` must be HASH, got INTEGER"},
+		{`slice([1, 2, 3, 4], 1, 3)`, "[2, 3]"},
+		{`slice([1, 2, 3, 4], 2)`, "[3, 4]"},
+		{`slice([1, 2, 3], -5, 10)`, "[1, 2, 3]"},
+		{`slice([1, 2, 3], 2, 1)`, "[]"},
+		{`slice([1], "a")`, "ERROR>> 1:6: Bounds given to `slice` must be INTEGER, got STRING"},
+		{`concat([1], [], [2, 3])`, "[1, 2, 3]"},
+		{`concat()`, "[]"},
+		{`concat([1], 2)`, "ERROR>> 1:7: Argument 2 to `concat` must be ARRAY, got INTEGER"},
+		{`bake a to [1, 2, 3]; [reverse(a), a]`, "[[3, 2, 1], [1, 2, 3]]"},
+		{`index_of([1, [2], "3"], [2.0])`, "1"},
+		{`index_of([1, 2], 3)`, "-1"},
+		{`contains(["a", "b"], "b")`, "true"},
+		{`contains([], 1)`, "false"},
+		{`contains("pie", "p")`, "ERROR>> 1:9: First argument to `contains` must be ARRAY, got STRING"},
+		{`insert([1, 3], 1, 2)`, "[1, 2, 3]"},
+		{`insert([], 0, 1)`, "[1]"},
+		{`insert([1], 2, 1)`, "ERROR>> 1:7: Index given to `insert` is out of range: 2"},
+		{`remove([1, 2, 3], 0)`, "[2, 3]"},
+		{`remove([1, 2, 3], 3)`, "ERROR>> 1:7: Index given to `remove` is out of range: 3"},
+		{`remove([], 0)`, "ERROR>> 1:7: Index given to `remove` is out of range: 0"},
+		{`range(4)`, "[0, 1, 2, 3]"},
+		{`range(2, 5)`, "[2, 3, 4]"},
+		{`range(10, 0, -3)`, "[10, 7, 4, 1]"},
+		{`range(0, 10, 4)`, "[0, 4, 8]"},
+		{`range(5, 0)`, "[]"},
+		{`range(-9223372036854775807 - 1, 9223372036854775807, 9223372036854775807)`,
+			"[-9223372036854775808, -1, 9223372036854775806]"},
+		{`range(0, 1, 0)`, "ERROR>> 1:6: Step given to `range` can't be 0"},
+		{`range(0, 9223372036854775807)`, "ERROR>> 1:6: Range given to `range` is too long: more than 16777216 elements"},
+		{`range(1.5)`, "ERROR>> 1:6: Arguments to `range` must be INTEGER, got FLOAT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("Wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestHigherOrderBuiltIns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], rc(x) { x * 2 })`, "[2, 4, 6]"},
+		{`map([], rc(x) { x })`, "[]"},
+		{`map([1.5, 2.5], floor)`, "[1, 2]"},
+		{`bake n to 10; map([1, 2], rc(x) { x + n })`, "[11, 12]"},
+		{`map([[1, 2], [3]], rc(xs) { map(xs, rc(x) { -x }) })`, "[[-1, -2], [-3]]"},
+		{`filter(range(10), rc(x) { x % 3 == 0 })`, "[0, 3, 6, 9]"},
+		{`reduce([1, 2, 3, 4], rc(acc, x) { acc + x })`, "10"},
+		{`reduce([], rc(acc, x) { acc + x }, 0)`, "0"},
+		{`reduce(["a", "b"], rc(acc, x) { acc + x }, ">")`, ">ab"},
+		{`reduce([], rc(acc, x) { acc })`, "ERROR>> 1:7: Cannot `reduce` an empty array without an initial value"},
+		{`bake total to 0; each([1, 2, 3], rc(x) { total += x }); total`, "6"},
+		{`[any([1, 3], rc(x) { x % 2 == 0 }), any([1, 2], rc(x) { x % 2 == 0 }), any([], rc(x) { true })]`, "[false, true, false]"},
+		{`[all([2, 4], rc(x) { x % 2 == 0 }), all([2, 3], rc(x) { x % 2 == 0 }), all([], rc(x) { false })]`, "[true, false, true]"},
+		{`bake calls to 0; any([1, 2, 3], rc(x) { calls += 1; x == 2 }); calls`, "2"},
+		{`sort([3, 1.5, 2, -1])`, "[-1, 1.5, 2, 3]"},
+		{`sort(["pie", "cake", "bun"])`, "[bun, cake, pie]"},
+		{`sort([3, 1, 2], rc(a, b) { a > b })`, "[3, 2, 1]"},
+		{`sort([3, 1, 2], rc(a, b) { a - b })`, "[1, 2, 3]"},
+		{`bake a to [2, 1]; sort(a); a`, "[2, 1]"},
+		{`sort([1, "a"])`, "ERROR>> 1:5: Cannot compare STRING and INTEGER"},
+		{`sort([1, 2], rc(a, b) { "a" })`, "ERROR>> 1:5: Comparator given to `sort` must serve BOOLEAN or a number, got STRING"},
+		{`sort_by(["ccc", "a", "bb", "d"], length)`, "[a, d, bb, ccc]"},
+		{`sort_by([{"n": 2}, {"n": 1}], rc(h) { h["n"] })`, "[{n: 1}, {n: 2}]"},
+		{`group_by(range(6), rc(x) { x % 3 })`, "{0: [0, 3], 1: [1, 4], 2: [2, 5]}"},
+		{`group_by(["ab", "c", "de"], length)`, "{2: [ab, de], 1: [c]}"},
+		{`group_by([1], rc(x) { {} })`, "ERROR>> 1:9: Unusable as hash key: HASH"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{`zip([1], [2], [3])`, "[[1, 2, 3]]"},
+		{`zip([1], 2)`, "ERROR>> 1:4: Argument 2 to `zip` must be ARRAY, got INTEGER"},
+		{`map(1, rc(x) { x })`, "ERROR>> 1:4: First argument to `map` must be ARRAY, got INTEGER"},
+		{`filter([1], 1)`, "ERROR>> 1:7: Second argument to `filter` must be RECIPE, got INTEGER"},
+		{`map([1], rc(a, b) { a })`, "ERROR>> 1:4: Wrong number of arguments to recipe, got=1, want=2"},
+		{`map([1, 0], rc(x) { 1 / x })`, "ERROR>> 1:23: Division by zero: 1 / 0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("Wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestIOBuiltIns(t *testing.T) {
+	tests := []struct {
+		input          string
+		stdin          string
+		expected       string
+		expectedStdout string
+	}{
+		{`plates("pie", 1, [2])`, "", "null", "pie\n1\n[2]\n"},
+		{`platef("%d cups of %s, %.1f%%, %v", 3, "flour", 2.5, [true])`, "", "null", "3 cups of flour, 2.5%, [true]\n"},
+		{`platef(1)`, "", "ERROR>> 1:7: First argument to `platef` must be STRING, got INTEGER", ""},
+		{`input()`, "first\nsecond\n", "first", ""},
+		{`input("name? ") + "!"`, "pie\r\n", "pie!", "name? "},
+		{`[input(), input(), input()]`, "one\nlast", "[one, last, null]", ""},
+		{`input(1)`, "", "ERROR>> 1:6: Argument to `input` must be STRING, got INTEGER", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout bytes.Buffer
+		ctx := object.NewContext(strings.NewReader(tt.stdin), &stdout, &stdout)
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, object.NewCookbookWithContext(ctx))
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("Wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("Wrong output for %q. expected=%q, got=%q", tt.input, tt.expectedStdout, stdout.String())
+		}
+	}
+}
+
 // Test Expressions
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []struct {
@@ -145,6 +395,11 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"10 % 3", 1},
+		{"-10 % 3", -1},
+		{"2 + 10 % 4 * 3", 8},
+		{"9223372036854775806 + 1", 9223372036854775807},
+		{"-9223372036854775807 - 1", -9223372036854775808},
 	}
 
 	for _, tt := range tests {
@@ -153,6 +408,116 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"10 / 4.0", 2.5},
+		{"2 * 0.25", 0.5},
+		{"1e3 - 1", 999},
+		{"7.5 % 2", 1.5},
+		{"float(10) / 3 * 3", 10},
+		{"round(2.345, 2)", 2.35},
+		{`float("0.5")`, 0.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestMixedNumberComparisons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 < 1.5", true},
+		{"2.5 > 3", false},
+		{"2 == 2.0", true},
+		{"2.0 != 2", false},
+		{"0.1 + 0.2 == 0.3", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestNumberConversionBuiltIns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{`int("42")`, 42},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(7)", 7},
+		{"floor(2.7)", 2},
+		{"ceil(2.1)", 3},
+		{"floor(-2.1)", -3},
+		{`int("4.2")`, `Could not convert "4.2" to INTEGER`},
+		{"int(1e300)", "Result of `int` is out of the INTEGER range: 1e+300"},
+		{"round(true)", "Argument to `round` must be INTEGER or FLOAT, got BOOLEAN"},
+		{"1.5 / 0", "Division by zero: 1.5 / 0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not an Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestIntegerArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"1 / 0", "Division by zero: 1 / 0"},
+		{"bake zero to 0; 7 % zero", "Division by zero: 7 % 0"},
+		{"9223372036854775807 + 1", "Integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", "Integer overflow: -9223372036854775807 - 2"},
+		{"4611686018427387904 * 2", "Integer overflow: 4611686018427387904 * 2"},
+		{"bake min to -9223372036854775807 - 1; min / -1", "Integer overflow: -9223372036854775808 / -1"},
+		{"bake min to -9223372036854775807 - 1; -min", "Integer overflow: -(-9223372036854775808)"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object served for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("Wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Cristiano Ronaldo!"`
 	expected := "Cristiano Ronaldo!"
@@ -207,6 +572,26 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" != "a"`, false},
+		{`"a" + "b" == "ab"`, true},
+		{`"1" == 1`, false},
+		{`["a"] == ["a"]`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] != [1, 2]", false},
+		{"[1, [2, 3]] == [1, [2, 4]]", false},
+		{"[1, 2] == [1, 2, 3]", false},
+		{"[1.0, 2] == [1, 2.0]", true},
+		{`{"a": [1], "b": 2} == {"b": 2, "a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{"[1] == 1", false},
+		{"bake f to rc() { 1 }; f == f", true},
+		{"rc() { 1 } == rc() { 1 }", false},
+		{"length == length", true},
+		{"bake a to [1]; a[0] = a; bake b to [1]; b[0] = b; a == b", true},
 	}
 
 	for _, tt := range tests {
@@ -343,12 +728,12 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong number of pairs, got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong number of pairs, got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := result.Get(expectedKey)
 		if !ok {
 			t.Fatalf("No key for key '%q' in pairs", expectedKey.Value)
 		}
@@ -357,6 +742,27 @@ func TestHashLiterals(t *testing.T) {
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"c": 1, "a": 2, "b": 3}`, "{c: 1, a: 2, b: 3}"},
+		{`bake h to {"x": 1}; h["a"] = 2; h["x"] = 3; h`, "{x: 3, a: 2}"},
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+		{`bake s to ""; for (k in {"c": 1, "a": 2, "b": 3}) { s = s + k }; s`, "cab"},
+		{`bake log to [0]; bake note to rc(x) { log = push(log, x); x };
+		  {note(1): note(2), note(3): note(4)}; log`, "[0, 1, 2, 3, 4]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("Wrong result for %q. expected=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -390,6 +796,38 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{[1, "a"]: 5}[[1, "a"]]`,
+			5,
+		},
+		{
+			`{[1, [2]]: 5}[[1, [2]]]`,
+			5,
+		},
+		{
+			`{[1, [2]]: 5}[[1, 2]]`,
+			nil,
+		},
+		{
+			`{["a", "bc"]: 5}[["ab", "c"]]`,
+			nil,
+		},
+		{
+			`{1: 5}[1.0]`,
+			5,
+		},
+		{
+			`bake grid to {}; for (row in 2) { for (col in 2) { grid[[row, col]] = row * 10 + col } }; grid[[1, 1]]`,
+			11,
+		},
+		{
+			`bake k to [1]; bake h to {}; h[k] = 5; k[0] = 2; h[[1]]`,
+			5,
+		},
+		{
+			`bake k to [1]; bake h to {}; h[k] = 5; k[0] = 2; h[[2]]`,
+			nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -487,6 +925,312 @@ func TestRecipeApplication(t *testing.T) {
 	}
 }
 
+func TestRecipeArity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"bake add to rc(a, b) { a + b }; add(1);", "Wrong number of arguments to `add`, got=1, want=2"},
+		{"bake add to rc(a, b) { a + b }; add(1, 2, 3);", "Wrong number of arguments to `add`, got=3, want=2"},
+		{"rc() { 1 }(1);", "Wrong number of arguments to recipe, got=1, want=0"},
+		{"bake add to rc(a, b = 2) { a + b }; add(1);", 3},
+		{"bake add to rc(a, b = 2) { a + b }; add(1, 5);", 6},
+		{"bake add to rc(a, b = 2) { a + b }; add();", "Wrong number of arguments to `add`, got=0, want=1 to 2"},
+		{"bake twice to rc(a, b = a * 2) { b }; twice(4);", 8},
+		{"bake count to rc(a, ...rest) { length(rest) }; count(1, 2, 3);", 2},
+		{"bake count to rc(a, ...rest) { length(rest) }; count(1);", 0},
+		{"bake count to rc(a, ...rest) { length(rest) }; count();", "Wrong number of arguments to `count`, got=0, want=at least 1"},
+		{"bake second to rc(...items) { items[1] }; second(7, 8, 9);", 8},
+		{"bake broken to rc(a = 1 + true) { a }; broken();", "Type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not an Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestArityErrorTrace(t *testing.T) {
+	input := "bake add to rc(a, b) { a + b };\nbake run to rc() { add(1) };\nrun();"
+
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("No error object served.")
+	}
+
+	expected := "\tat run (2:23)\n\tat <main> (3:4)\n"
+	if errObj.StackTrace() != expected {
+		t.Errorf("Wrong stack trace. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+}
+
+func TestRecipeDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"recipe add(a, b) { a + b } add(2, 3);", 5},
+		{"recipe fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } } fact(5);", 120},
+		{
+			`is_even(10);
+			recipe is_even(n) { if (n == 0) { true } else { is_odd(n - 1) } }
+			recipe is_odd(n) { if (n == 0) { false } else { is_even(n - 1) } }`,
+			true,
+		},
+		{
+			`recipe outer() {
+				serves inner();
+				recipe inner() { 42 }
+			}
+			outer();`,
+			42,
+		},
+		{"recipe add(a, b) { a + b } add(1);", "Wrong number of arguments to `add`, got=1, want=2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not an Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestNamedRecipeInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"recipe add(a, b) { a + b } add;", "recipe add(a, b) {\n(a + b)\n}"},
+		{"bake twice to rc(x) { x * 2 }; twice;", "recipe twice(x) {\n(x * 2)\n}"},
+		{"rc(x) { x };", "recipe(x) {\nx\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("Wrong Inspect(). expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"bake i to 0; while (i < 5) { bake i to i + 1; } i;", 5},
+		{"bake i to 0; while (true) { bake i to i + 1; if (i == 3) { break; } } i;", 3},
+		{"while (false) { 1 }", nil},
+		{"recipe find() { bake i to 0; while (true) { bake i to i + 1; if (i > 6) { serves i; } } } find();", 7},
+		{"while (1 + true) { 1 }", "Type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	testLoops(t, tests)
+}
+
+func TestForLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"bake sum to 0; for (x in [1, 2, 3]) { bake sum to sum + x; } sum;", 6},
+		{"bake sum to 0; for (i, x in [5, 6, 7]) { bake sum to sum + i; } sum;", 3},
+		{"bake sum to 0; for (i in 5) { bake sum to sum + i; } sum;", 10},
+		{`bake out to ""; for (c in "abc") { bake out to c + out; } length(out);`, 3},
+		{`bake sum to 0; for (k in {1: "a", 2: "b"}) { bake sum to sum + k; } sum;`, 3},
+		{`bake sum to 0; for (k, v in {"a": 1, "b": 2}) { bake sum to sum + v; } sum;`, 3},
+		{"bake sum to 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } bake sum to sum + x; } sum;", 8},
+		{"bake sum to 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } bake sum to sum + x; } sum;", 3},
+		{"recipe first_even(xs) { for (x in xs) { if (x % 2 == 0) { serves x; } } } first_even([1, 3, 4, 6]);", 4},
+		{"for (x in [1, 2]) { x + true }", "Type mismatch: INTEGER + BOOLEAN"},
+		{"for (x in true) { x }", "Cannot iterate over BOOLEAN"},
+		{"for (x in []) { x }", nil},
+	}
+
+	testLoops(t, tests)
+}
+
+func TestLargeLoop(t *testing.T) {
+	input := `
+		bake n to 0;
+		bake count to 0;
+		while (n < 100000) { bake n to n + 1; }
+		for (i in n) { bake count to count + 1; }
+		count;
+	`
+
+	testIntegerObject(t, testEval(input), 100000)
+}
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input           string
+		ctx             context.Context
+		limits          object.Limits
+		expectedLimit   object.Limit
+		expectedMessage string
+	}{
+		{"bake f to rc() { f() }; f()", context.Background(), object.Limits{},
+			object.DEPTH_LIMIT, "Stack overflow: more than 10000 nested recipe calls"},
+		{"bake f to rc(n) { f(n + 1) }; f(0)", context.Background(), object.Limits{MaxDepth: 50},
+			object.DEPTH_LIMIT, "Stack overflow: more than 50 nested recipe calls"},
+		{"while (true) {}", context.Background(), object.Limits{MaxSteps: 1000},
+			object.STEP_LIMIT, "Step limit exceeded: more than 1000 steps"},
+		{"while (true) {}", context.Background(), object.Limits{Timeout: 10 * time.Millisecond},
+			object.TIMEOUT, "Timeout: the program ran for too long"},
+		{"while (true) {}", cancelled, object.Limits{},
+			object.CANCELLED, "Cancelled: the program was stopped by its host"},
+		{`bake s to "pie"; while (true) { s = s + s }`, context.Background(), object.Limits{MaxMemory: 1 << 20},
+			object.MEMORY_LIMIT, "Memory limit exceeded: more than 1048576 bytes allocated"},
+		{"bake a to [0]; while (true) { a = push(a, 1) }", context.Background(), object.Limits{MaxMemory: 1 << 16},
+			object.MEMORY_LIMIT, "Memory limit exceeded: more than 65536 bytes allocated"},
+		{"range(1000000)", context.Background(), object.Limits{MaxMemory: 1 << 20},
+			object.MEMORY_LIMIT, "Memory limit exceeded: more than 1048576 bytes allocated"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(tt.ctx, program, object.NewCookbook(), tt.limits)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object served for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Limit != tt.expectedLimit || errObj.Message != tt.expectedMessage {
+			t.Errorf("Wrong error for %q. expected=%s %q, got=%s %q",
+				tt.input, tt.expectedLimit, tt.expectedMessage, errObj.Limit, errObj.Message)
+		}
+	}
+
+	// Limits only hold during EvalContext, later runs on the same cookbook are unlimited again
+	book := object.NewCookbook()
+	program := parser.New(lexer.New("bake i to 0; while (i < 1000) { i += 1 }; i")).ParseProgram()
+	EvalContext(context.Background(), program, book, object.Limits{MaxSteps: 10})
+	testIntegerObject(t, Eval(program, book), 1000)
+}
+
+func testLoops(t *testing.T, tests []struct {
+	input    string
+	expected interface{}
+}) {
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not an Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"bake x to 1; x to 5; x;", 5},
+		{"bake x to 1; x = x + 1;", 2},
+		{"bake x to 10; x += 5; x -= 3; x *= 2; x /= 4; x %= 4; x;", 2},
+		{"bake a to 1; bake b to 1; a = b = 7; a + b;", 14},
+		{
+			`bake counter to 0;
+			bake increment to rc() { counter += 1 };
+			increment(); increment(); increment();
+			counter;`,
+			3,
+		},
+		{
+			`recipe make_counter() {
+				bake count to 0;
+				rc() { count += 1 }
+			}
+			bake next to make_counter();
+			next(); next();`,
+			2,
+		},
+		{"bake arr to [1, 2, 3]; arr[0] to 5; arr[0] + arr[1];", 7},
+		{"bake arr to [1, 2, 3]; arr[2] += 10; arr[2];", 13},
+		{"bake arr to [1, 2]; bake alias to arr; alias[1] = 9; arr[1];", 9},
+		{`bake h to {"k": 1}; h["k"] to 5; h["k"];`, 5},
+		{`bake h to {}; h["new"] = 3; h["new"] *= 2; h["new"];`, 6},
+		{"y to 5;", "Cannot assign to undefined name: y"},
+		{"length to 5;", "Cannot assign to undefined name: length"},
+		{"bake arr to [1]; arr[3] to 5;", "Index out of range: 3, length is 1"},
+		{"bake s to \"abc\"; s[0] to 5;", "Index assignment not supported: STRING"},
+		{"bake h to {}; h[[1]] to 5; h[[1]];", 5},
+		{"bake h to {}; h[{}] to 5;", "Unusable as hash key: HASH"},
+		{"bake h to {}; h[[1, {}]] to 5;", "Unusable as hash key: ARRAY"},
+		{"bake a to [1]; a[0] = a; bake h to {}; h[a] to 5;", "Unusable as hash key: ARRAY"},
+		{"bake h to {[1]: 1}; for (k in h) { k[0] = 2 };", "Cannot assign to a frozen array: hash keys can't be changed"},
+		{"bake x to 1; x += true;", "Type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not an Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	input := `
 		bake newAdder to recipe(x) {
@@ -542,6 +1286,19 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if math.Abs(result.Value-expected) > 1e-9 {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
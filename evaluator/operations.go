@@ -0,0 +1,54 @@
+package evaluator
+
+import (
+	"cottagepie/object"
+	"sort"
+)
+
+// The operations below are shared with the bytecode vm, so both engines agree on the
+// semantics of operators, indexing and built-ins
+
+func InfixOperation(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// IntegerArithmetic applies + - * / or % to two integers, reporting overflows and divisions by zero
+func IntegerArithmetic(operator string, left, right int64) object.Object {
+	return evalIntegerArithmetic(operator, left, right)
+}
+
+func PrefixOperation(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func IndexOperation(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+func IndexAssignment(left, index, value object.Object) object.Object {
+	return evalIndexAssignment(left, index, value)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+func LookupBuiltIn(name string) (*object.BuiltIn, bool) {
+	built_in, ok := built_ins[name]
+	return built_in, ok
+}
+
+// BuiltInNames returns the name of every built-in in alphabetical order, eg: for completion
+func BuiltInNames() []string {
+	names := make([]string, 0, len(built_ins))
+	for name := range built_ins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyRecipe calls a recipe or built-in with already evaluated arguments, eg: from a Go host
+func ApplyRecipe(ctx *object.Context, rc object.Object, args []object.Object) object.Object {
+	return applyRecipe(ctx, rc, args)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cottagepie/formatter"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// formatCommand formats the given files and the .pie files of the given directories in place,
+// or stdin to stdout without any. With --check or --diff nothing is written: --check lists
+// the files that aren't formatted and fails if there are any, --diff shows what would change
+func formatCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("cottagepie fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, USAGE) }
+	check := flags.Bool("check", false, "list the files that aren't formatted, without changing them")
+	diff := flags.Bool("diff", false, "show the changes formatting would make")
+
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	if flags.NArg() == 0 {
+		source, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not read stdin: %s\n", err)
+			return EXIT_USAGE
+		}
+		return formatSource("<stdin>", string(source), *check, *diff, stdout, stderr, func(formatted string) error {
+			_, err := io.WriteString(stdout, formatted)
+			return err
+		})
+	}
+
+	files, err := pieFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read file: %s\n", err)
+		return EXIT_USAGE
+	}
+
+	status := EXIT_OK
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not read file: %s\n", err)
+			return EXIT_USAGE
+		}
+
+		code := formatSource(file, string(source), *check, *diff, stdout, stderr, func(formatted string) error {
+			if formatted == string(source) {
+				return nil
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(file, []byte(formatted), info.Mode())
+		})
+
+		// Parse errors win over unformatted files, both over success
+		if code > status {
+			status = code
+		}
+	}
+	return status
+}
+
+// formatSource formats one source, write saves the result when neither check nor diff are set
+func formatSource(name string, source string, check bool, diff bool, stdout, stderr io.Writer, write func(formatted string) error) int {
+	formatted, errors := formatter.Format(name, source)
+	if len(errors) != 0 {
+		printDiagnostics(stderr, errors)
+		return EXIT_PARSE_ERROR
+	}
+
+	if !check && !diff {
+		if err := write(formatted); err != nil {
+			fmt.Fprintf(stderr, "Could not write file: %s\n", err)
+			return EXIT_USAGE
+		}
+		return EXIT_OK
+	}
+
+	if formatted == source {
+		return EXIT_OK
+	}
+
+	if diff {
+		fmt.Fprint(stdout, formatter.Diff(name, source, formatted))
+	} else {
+		fmt.Fprintln(stdout, name)
+	}
+
+	if check {
+		return EXIT_UNFORMATTED
+	}
+	return EXIT_OK
+}
+
+// pieFiles returns the paths that are files and the .pie files found in the ones that are directories
+func pieFiles(paths []string) ([]string, error) {
+	files := []string{}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(file) == ".pie" {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,189 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DIFF_CONTEXT is the number of unchanged lines shown around each change
+const DIFF_CONTEXT = 3
+
+// edit is a line of a diff: kept (' '), removed ('-') or added ('+')
+type edit struct {
+	kind byte
+	line string // with its line ending, if it has one
+}
+
+// Diff returns a unified diff from before to after, eg: to show what formatting a file
+// would change. It is empty when they are the same
+func Diff(name string, before string, after string) string {
+	if before == after {
+		return ""
+	}
+
+	edits := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s (formatted)\n", name, name)
+
+	// Hunks go from DIFF_CONTEXT lines before a change to DIFF_CONTEXT lines after the
+	// last change, changes at most twice that many lines apart share a hunk
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i += 1
+			continue
+		}
+
+		first := max(i-DIFF_CONTEXT, 0)
+		last := i
+		for j := i; j < len(edits) && j <= last+2*DIFF_CONTEXT+1; j++ {
+			if edits[j].kind != ' ' {
+				last = j
+			}
+		}
+		end := min(last+DIFF_CONTEXT+1, len(edits))
+
+		writeHunk(&out, edits, first, end)
+		i = end
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, edits []edit, first int, end int) {
+	// Line numbers of the first line of the hunk in both versions
+	beforeLine, afterLine := 1, 1
+	for _, e := range edits[:first] {
+		if e.kind != '+' {
+			beforeLine += 1
+		}
+		if e.kind != '-' {
+			afterLine += 1
+		}
+	}
+
+	beforeCount, afterCount := 0, 0
+	for _, e := range edits[first:end] {
+		if e.kind != '+' {
+			beforeCount += 1
+		}
+		if e.kind != '-' {
+			afterCount += 1
+		}
+	}
+
+	// An empty range is numbered after the line it follows
+	if beforeCount == 0 {
+		beforeLine -= 1
+	}
+	if afterCount == 0 {
+		afterLine -= 1
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", beforeLine, beforeCount, afterLine, afterCount)
+	for _, e := range edits[first:end] {
+		out.WriteByte(e.kind)
+		out.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits text after each line ending
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines finds the shortest way to edit a into b with the Myers algorithm: v holds how
+// far each diagonal k = x - y got with d edits. Only the 2d+1 diagonals reachable with d
+// edits are kept for every d to retrace the path, so memory grows with the edits, not the lines
+func diffLines(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	trace := [][]int{}
+
+search:
+	for d := 0; d <= n+m; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				break search
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	// Walk the path back from the end, collecting the edits in reverse. Diagonal k of the
+	// previous d is at index k+d-1 of its trace
+	edits := []edit{}
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		previous := trace[d-1]
+		k := x - y
+
+		var previousK int
+		if k == -d || (k != d && previous[k-1+d-1] < previous[k+1+d-1]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := previous[previousK+d-1]
+		previousY := previousX - previousK
+
+		for x > previousX && y > previousY {
+			x, y = x-1, y-1
+			edits = append(edits, edit{' ', a[x]})
+		}
+
+		if x == previousX {
+			edits = append(edits, edit{'+', b[previousY]})
+		} else {
+			edits = append(edits, edit{'-', a[previousX]})
+		}
+		x, y = previousX, previousY
+	}
+
+	// What is left is the lines both start with
+	for x > 0 {
+		x -= 1
+		edits = append(edits, edit{' ', a[x]})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
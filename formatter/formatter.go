@@ -0,0 +1,520 @@
+package formatter
+
+import (
+	"cottagepie/ast"
+	"cottagepie/diagnostic"
+	"cottagepie/lexer"
+	"cottagepie/parser"
+	"cottagepie/token"
+	"strings"
+)
+
+// INDENT is what each level of blocks and multi-line collections is indented with
+const INDENT = "  "
+
+// Format parses source and prints it back in the canonical format: one statement per line,
+// blocks indented, single spaces around operators and semicolons after statements, except
+// after the last one of a block which is what it serves. Comments and single blank lines are
+// kept, as are blocks and collections written on one line, eg: rc(x) { x * 2 }.
+// A collection whose first element starts on its own line gets one element per line.
+// When source doesn't parse the parser diagnostics are returned instead
+func Format(file string, source string) (string, []diagnostic.Diagnostic) {
+	p := parser.New(lexer.NewWithFile(file, source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", p.Errors()
+	}
+
+	pr := newPrinter(file, source)
+
+	// The lexer skips the shebang line, it is kept as it was
+	if strings.HasPrefix(source, "#!") {
+		shebang := source
+		if i := strings.IndexByte(source, '\n'); i != -1 {
+			shebang = source[:i]
+		}
+		pr.write(strings.TrimRight(shebang, " \t\r") + "\n")
+	}
+
+	pr.statements(program.Statements, pr.eof, false)
+	return pr.out.String(), nil
+}
+
+// Statements prints already parsed statements in the canonical format, one per line, each
+// line starting with indent and one level of INDENT, eg: to show the body of a recipe value.
+// Without the source, comments are lost and blocks go on one line when their single statement
+// started on the line of their brace
+func Statements(stmts []ast.Statement, indent string) string {
+	p := &printer{
+		prefix:  indent,
+		indent:  1,
+		fresh:   true,
+		blanks:  map[token.Position]bool{},
+		closers: map[token.Position]token.Position{},
+	}
+	p.statements(stmts, token.Position{}, true)
+	return p.out.String()
+}
+
+// comment is a comment of the source along with where it sits compared to the tokens around it
+type comment struct {
+	token.Comment
+	trailing bool // on the same line as the token before it, it stays at the end of that line
+	blank    bool // a blank line separates it from what comes before
+}
+
+// printer writes the nodes of a program, the comments of the source are printed before
+// the first node that comes after them
+type printer struct {
+	out    strings.Builder
+	prefix string // written before the indentation of every line
+	indent int
+	fresh  bool // nothing was written since the block or collection was opened
+
+	comments []comment
+	next     int // the first comment not printed yet
+
+	blanks  map[token.Position]bool           // tokens separated by a blank line from what comes before them
+	closers map[token.Position]token.Position // the position of the bracket closing each opening one
+	eof     token.Position
+}
+
+// newPrinter reads the tokens of source for what the syntax tree doesn't keep: comments,
+// blank lines and where brackets are closed
+func newPrinter(file string, source string) *printer {
+	p := &printer{
+		fresh:   true,
+		blanks:  map[token.Position]bool{},
+		closers: map[token.Position]token.Position{},
+	}
+
+	l := lexer.NewWithFile(file, source)
+	openers := []token.Position{}
+	end := 0 // the line the previous token or comment ends on
+
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		trailing := end > 0
+		for _, c := range tok.Comments {
+			c.Text = strings.TrimRight(c.Text, " \t\r")
+			trailing = trailing && c.Pos.Line == end
+			p.comments = append(p.comments, comment{Comment: c, trailing: trailing, blank: end > 0 && c.Pos.Line > end+1})
+			end = c.Pos.Line + strings.Count(c.Text, "\n")
+		}
+		p.blanks[tok.Pos] = end > 0 && tok.Pos.Line > end+1
+		end = tok.Pos.Line + strings.Count(tok.Literal, "\n")
+
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			openers = append(openers, tok.Pos)
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			if len(openers) > 0 {
+				p.closers[openers[len(openers)-1]] = tok.Pos
+				openers = openers[:len(openers)-1]
+			}
+		case token.EOF:
+			p.eof = tok.Pos
+			return p
+		}
+	}
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.write("\n" + p.prefix + strings.Repeat(INDENT, p.indent))
+}
+
+func (p *printer) writeIndent() {
+	p.write(p.prefix + strings.Repeat(INDENT, p.indent))
+}
+
+// emptyLine keeps a blank line of the source, unless it opened a block or a collection
+func (p *printer) emptyLine() {
+	if !p.fresh {
+		p.write("\n")
+	}
+}
+
+// pending tells if a comment not printed yet comes before pos
+func (p *printer) pending(pos token.Position) bool {
+	if p.next >= len(p.comments) {
+		return false
+	}
+	c := p.comments[p.next].Pos
+	return c.Line < pos.Line || (c.Line == pos.Line && c.Column < pos.Column)
+}
+
+// leading prints the comments before pos on their own lines
+func (p *printer) leading(pos token.Position) {
+	for p.pending(pos) {
+		c := p.comments[p.next]
+		p.next += 1
+
+		if c.blank {
+			p.emptyLine()
+		}
+		p.writeIndent()
+		p.write(c.Text + "\n")
+		p.fresh = false
+	}
+}
+
+// trailing prints the comments before pos that were at the end of the line written last
+func (p *printer) trailing(pos token.Position) {
+	for p.pending(pos) && p.comments[p.next].trailing {
+		p.write(" " + p.comments[p.next].Text)
+		p.next += 1
+	}
+}
+
+// inline prints the comments before pos in the middle of a line, a line comment continues the line below
+func (p *printer) inline(pos token.Position) {
+	for p.pending(pos) {
+		c := p.comments[p.next]
+		p.next += 1
+
+		p.write(c.Text)
+		if strings.HasPrefix(c.Text, "//") {
+			p.indent += 1
+			p.newline()
+			p.indent -= 1
+		} else {
+			p.write(" ")
+		}
+	}
+}
+
+// statements prints one statement per line, end is the position of what follows them
+func (p *printer) statements(stmts []ast.Statement, end token.Position, inBlock bool) {
+	for i, stmt := range stmts {
+		p.leading(stmt.Pos())
+		if p.blanks[stmt.Pos()] {
+			p.emptyLine()
+		}
+		p.writeIndent()
+
+		var next ast.Statement
+		nextPos := end
+		if i+1 < len(stmts) {
+			next, nextPos = stmts[i+1], stmts[i+1].Pos()
+		}
+
+		p.statement(stmt, inBlock && next == nil, next)
+		p.trailing(nextPos)
+		p.write("\n")
+		p.fresh = false
+	}
+	p.leading(end)
+}
+
+// statement prints stmt, last is set for the last statement of a block, its value is
+// served so it goes without a semicolon
+func (p *printer) statement(stmt ast.Statement, last bool, next ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.BakeStatement:
+		p.write("bake " + stmt.Name.Value + " to ")
+		p.expression(stmt.Value, parser.LOWEST)
+		p.write(";")
+
+	case *ast.ServesStatement:
+		p.write("serves")
+		if stmt.ServesValue != nil {
+			p.write(" ")
+			p.expression(stmt.ServesValue, parser.LOWEST)
+		}
+		p.write(";")
+
+	case *ast.RecipeDeclaration:
+		p.write(stmt.Token.Literal + " " + stmt.Name.Value)
+		p.recipe(stmt.Recipe)
+
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expression(stmt.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body)
+
+	case *ast.ForStatement:
+		p.write("for (" + stmt.Key.Value)
+		if stmt.Value != nil {
+			p.write(", " + stmt.Value.Value)
+		}
+		p.write(" in ")
+		p.expression(stmt.Iterable, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body)
+
+	case *ast.BreakStatement:
+		p.write("break;")
+
+	case *ast.ContinueStatement:
+		p.write("continue;")
+
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression, parser.LOWEST)
+
+		// An if ends with its block like a statement, it only needs a semicolon when the
+		// next statement would continue it, eg: (x) would call what it serves
+		_, isIf := stmt.Expression.(*ast.IfExpression)
+		if !last && (!isIf || continues(next)) {
+			p.write(";")
+		}
+	}
+}
+
+// block prints a block on one line when it was written on one and has a single statement,
+// otherwise one statement per line
+func (p *printer) block(b *ast.BlockStatement) {
+	end, known := p.closers[b.Token.Pos]
+	oneLine := end.Line == b.Token.Pos.Line
+	if !known && len(b.Statements) > 0 {
+		oneLine = b.Statements[0].Pos().Line == b.Token.Pos.Line
+	}
+
+	if !p.pending(end) {
+		if len(b.Statements) == 0 {
+			p.write("{}")
+			return
+		}
+		if len(b.Statements) == 1 && oneLine {
+			p.write("{ ")
+			p.statement(b.Statements[0], true, nil)
+			p.write(" }")
+			return
+		}
+	}
+
+	p.write("{")
+	p.indent += 1
+	p.fresh = true
+	if len(b.Statements) > 0 {
+		p.trailing(b.Statements[0].Pos())
+	}
+	p.write("\n")
+
+	p.statements(b.Statements, end, true)
+	p.indent -= 1
+	p.writeIndent()
+	p.write("}")
+}
+
+// list prints the n items of a collection or the arguments of a call between open and close,
+// one per line when the first one was written on a line of its own
+func (p *printer) list(open string, close string, openPos token.Position, n int, startOf func(i int) token.Position, item func(i int)) {
+	p.write(open)
+
+	if n == 0 || startOf(0).Line == openPos.Line {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				p.write(", ")
+			}
+			item(i)
+		}
+		p.write(close)
+		return
+	}
+
+	end := p.closers[openPos]
+	p.indent += 1
+	p.fresh = true
+	p.trailing(startOf(0))
+	p.write("\n")
+
+	for i := 0; i < n; i++ {
+		p.leading(startOf(i))
+		if p.blanks[startOf(i)] {
+			p.emptyLine()
+		}
+		p.writeIndent()
+		item(i)
+
+		next := end
+		if i+1 < n {
+			p.write(",")
+			next = startOf(i + 1)
+		}
+		p.trailing(next)
+		p.write("\n")
+		p.fresh = false
+	}
+
+	p.leading(end)
+	p.indent -= 1
+	p.writeIndent()
+	p.write(close)
+}
+
+// recipe prints the parameters and body of a recipe, after its keyword and name
+func (p *printer) recipe(lit *ast.RecipeLiteral) {
+	p.write("(")
+	for i, param := range lit.Parameters {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Value)
+		if i < len(lit.Defaults) && lit.Defaults[i] != nil {
+			p.write(" = ")
+			p.expression(lit.Defaults[i], parser.LOWEST)
+		}
+	}
+	if lit.Rest != nil {
+		if len(lit.Parameters) > 0 {
+			p.write(", ")
+		}
+		p.write("..." + lit.Rest.Value)
+	}
+	p.write(") ")
+	p.block(lit.Body)
+}
+
+// expression prints e, in parentheses when it binds less tightly than the operator around it
+func (p *printer) expression(e ast.Expression, around int) {
+	p.inline(start(e))
+
+	if precedence(e) < around {
+		p.write("(")
+		defer p.write(")")
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean:
+		p.write(e.TokenLiteral())
+	case *ast.StringLiteral:
+		p.write(quote(e.Value))
+
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		// --x would read as a single operator
+		if right, ok := e.Right.(*ast.PrefixExpression); ok && right.Operator == e.Operator && e.Operator == "-" {
+			p.write("(")
+			p.expression(e.Right, parser.LOWEST)
+			p.write(")")
+		} else {
+			p.expression(e.Right, parser.PREFIX)
+		}
+
+	case *ast.InfixExpression:
+		// Operators are left associative, a right operand with the same precedence needs parentheses
+		operator := parser.Precedence(e.Token.Type)
+		p.expression(e.Left, operator)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Right, operator+1)
+
+	case *ast.AssignExpression:
+		p.expression(e.Target, parser.ASSIGNMENT+1)
+		p.write(" " + e.Token.Literal + " ")
+		p.expression(e.Value, parser.LOWEST)
+
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(e.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative)
+		}
+
+	case *ast.RecipeLiteral:
+		p.write(e.Token.Literal)
+		p.recipe(e)
+
+	case *ast.CallExpression:
+		p.expression(e.Recipe, parser.CALL)
+		p.list("(", ")", e.Token.Pos, len(e.Arguments),
+			func(i int) token.Position { return start(e.Arguments[i]) },
+			func(i int) { p.expression(e.Arguments[i], parser.LOWEST) })
+
+	case *ast.IndexExpression:
+		p.expression(e.Left, parser.INDEX)
+		p.write("[")
+		p.expression(e.Index, parser.LOWEST)
+		p.write("]")
+
+	case *ast.ArrayLiteral:
+		p.list("[", "]", e.Token.Pos, len(e.Elements),
+			func(i int) token.Position { return start(e.Elements[i]) },
+			func(i int) { p.expression(e.Elements[i], parser.LOWEST) })
+
+	case *ast.HashLiteral:
+		p.list("{", "}", e.Token.Pos, len(e.Pairs),
+			func(i int) token.Position { return start(e.Pairs[i].Key) },
+			func(i int) {
+				p.expression(e.Pairs[i].Key, parser.LOWEST)
+				p.write(": ")
+				p.expression(e.Pairs[i].Value, parser.LOWEST)
+			})
+	}
+}
+
+// precedence is how tightly e binds. Ifs and recipes are put in parentheses when they are
+// operands, what follows them would otherwise be hard to tell apart from their block
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.AssignExpression:
+		return parser.ASSIGNMENT
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Token.Type)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.IfExpression, *ast.RecipeLiteral:
+		return parser.LOWEST
+	default:
+		return parser.INDEX + 1
+	}
+}
+
+// start is the position of the first token of e, the position of operators is the operator itself
+func start(e ast.Expression) token.Position {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return start(e.Left)
+	case *ast.AssignExpression:
+		return start(e.Target)
+	case *ast.CallExpression:
+		return start(e.Recipe)
+	case *ast.IndexExpression:
+		return start(e.Left)
+	default:
+		return e.Pos()
+	}
+}
+
+// continues tells if stmt, printed after an expression, would be read as part of it: when
+// it starts with a parenthesis, a bracket or a minus
+func continues(stmt ast.Statement) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	return ok && startsOperand(es.Expression)
+}
+
+func startsOperand(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return precedence(e.Left) < parser.Precedence(e.Token.Type) || startsOperand(e.Left)
+	case *ast.AssignExpression:
+		return startsOperand(e.Target)
+	case *ast.CallExpression:
+		return precedence(e.Recipe) < parser.CALL || startsOperand(e.Recipe)
+	case *ast.IndexExpression:
+		return precedence(e.Left) < parser.INDEX || startsOperand(e.Left)
+	case *ast.PrefixExpression:
+		return e.Operator == "-"
+	case *ast.ArrayLiteral:
+		return true
+	default:
+		return false
+	}
+}
+
+// quote writes s between double quotes, or single ones when it contains a double quote.
+// Strings have no escapes so they can't contain both
+func quote(s string) string {
+	if strings.Contains(s, `"`) {
+		return "'" + s + "'"
+	}
+	return `"` + s + `"`
+}
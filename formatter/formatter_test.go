@@ -0,0 +1,132 @@
+package formatter
+
+import (
+	"cottagepie/lexer"
+	"cottagepie/parser"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"bake   x to 1+2*3", "bake x to 1 + 2 * 3;\n"},
+		{"bake x = 5;", "bake x to 5;\n"},
+		{"x = 5\nx += 1;", "x = 5;\nx += 1;\n"},
+		{"(1 + 2) * (3 - 4) - (5 - 6)", "(1 + 2) * (3 - 4) - (5 - 6);\n"},
+		{"-(1 + 2); -(-1); !!true", "-(1 + 2);\n-(-1);\n!!true;\n"},
+		{"'pie'; 'say \"hi\"'", "\"pie\";\n'say \"hi\"';\n"},
+		{"[1,2 , 3]; {'a':1,'b' : [ ]}; {}", "[1, 2, 3];\n{\"a\": 1, \"b\": []};\n{};\n"},
+		{"bake add to rc(a,b){a+b};", "bake add to rc(a, b) { a + b };\n"},
+		{"bake add to rc(a, b) { a + b; }", "bake add to rc(a, b) { a + b };\n"},
+		{"bake f to rc() {   }", "bake f to rc() {};\n"},
+		{"recipe  scale(amount, factor=2, ...notes) {\nbake x to amount;\n    x * factor;\n}",
+			"recipe scale(amount, factor = 2, ...notes) {\n  bake x to amount;\n  x * factor\n}\n"},
+		{"if (x) { 1 } else { 2 }\nplates(1)", "if (x) { 1 } else { 2 }\nplates(1);\n"},
+		{"if (x) { 1 };\n[2]", "if (x) { 1 };\n[2];\n"},
+		{"(rc(x) { x })(1)", "(rc(x) { x })(1);\n"},
+		{"for (i,amount in [3, 0]) {\nif (amount == 0) { continue; }\n}", "for (i, amount in [3, 0]) {\n  if (amount == 0) { continue; }\n}\n"},
+		{"while (x < 3) {\n\n\nx += 1\n\n\n\nbreak;\n}", "while (x < 3) {\n  x += 1;\n\n  break;\n}\n"},
+		{"map(xs, rc(x) {\nx * 2\n})", "map(xs, rc(x) {\n  x * 2\n});\n"},
+		{"{\n'name': 'pie',\n'count':\n2}", "{\n  \"name\": \"pie\",\n  \"count\": 2\n};\n"},
+		{"#!/usr/bin/env cottagepie\nplates(1)", "#!/usr/bin/env cottagepie\nplates(1);\n"},
+		{"", ""},
+
+		// Comments
+		{"// only a comment", "// only a comment\n"},
+		{"bake x to 1;    // one\n\n\n/* two */\nbake y to 2", "bake x to 1; // one\n\n/* two */\nbake y to 2;\n"},
+		{"bake f to rc() { // nothing yet\n}", "bake f to rc() {\n  // nothing yet\n};\n"},
+		{"bake f to rc(x) { x /* same */ }", "bake f to rc(x) {\n  x /* same */\n};\n"},
+		{"bake f to rc(x) {\n  x * 2 // doubled\n  // done\n}", "bake f to rc(x) {\n  x * 2 // doubled\n  // done\n};\n"},
+		{"[\n  1, // one\n  // two\n  2\n]", "[\n  1, // one\n  // two\n  2\n];\n"},
+		{"add(1, /* b */ 2)", "add(1, /* b */ 2);\n"},
+		{"bake x to 1 // last\n", "bake x to 1; // last\n"},
+	}
+
+	for _, tt := range tests {
+		actual, errors := Format("", tt.input)
+		if len(errors) != 0 {
+			t.Errorf("Errors formatting %q: %v", tt.input, errors)
+			continue
+		}
+		if actual != tt.expected {
+			t.Errorf("Wrong format for %q. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+
+		again, _ := Format("", actual)
+		if again != actual {
+			t.Errorf("Formatting %q again changed it. expected=%q, got=%q", tt.input, actual, again)
+		}
+	}
+}
+
+func TestFormatKeepsMeaning(t *testing.T) {
+	inputs := []string{
+		"bake total to 0; for (i, amount in [3, 0, 5]) { if (amount == 0) { continue; } bake total to total + amount; }",
+		"recipe is_even(n) { if (n == 0) { true } else { is_odd(n - 1) } } recipe is_odd(n) { if (n == 0) { false } else { is_even(n - 1) } }",
+		"bake prices to [4, 12, 7]; reduce(map(prices, rc(p) { p * 2 }), rc(total, p) { total + p }, 0)",
+		"a - (b - c) * -d[0] / (e + f)(g) % 2 == (h != i) < j",
+		"bake grid to {}; grid[[0, 1]] = 'pie'; grid[[0, 1]] += 'crust'",
+		"bake x to if (a) { 1 } else { 2 } + 1; x to y to 3",
+		"if (a) { b }\n(c)\nif (a) { b }\n-1\nif (a) { b }\n[1]",
+		"bake count to 0; bake increment to rc() { count += 1 }; serves increment();",
+	}
+
+	for _, input := range inputs {
+		formatted, errors := Format("", input)
+		if len(errors) != 0 {
+			t.Errorf("Errors formatting %q: %v", input, errors)
+			continue
+		}
+
+		expected := parse(t, input)
+		if actual := parse(t, formatted); actual != expected {
+			t.Errorf("Formatting %q changed what it means. expected=%q, got=%q from %q", input, expected, actual, formatted)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("Errors parsing %q: %v", input, p.Errors())
+	}
+	return program.String()
+}
+
+func TestFormatErrors(t *testing.T) {
+	formatted, errors := Format("bad.pie", "bake to 1;")
+	if formatted != "" || len(errors) == 0 {
+		t.Fatalf("Expected parse errors, got=%q", formatted)
+	}
+	if errors[0].Start.File != "bad.pie" {
+		t.Errorf("Wrong file in error position, got=%q", errors[0].Start.File)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		before   string
+		after    string
+		expected string
+	}{
+		{"a\n", "a\n", ""},
+		{"a\nb\nc\n", "a\nB\nc\n", "--- x.pie\n+++ x.pie (formatted)\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"", "a\n", "--- x.pie\n+++ x.pie (formatted)\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"a", "a\n", "--- x.pie\n+++ x.pie (formatted)\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+		// Changes far apart get their own hunk, with three lines of context
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n", "0\n2\n3\n4\n5\n6\n7\n8\n9\n10\n12\n",
+			"--- x.pie\n+++ x.pie (formatted)\n@@ -1,4 +1,4 @@\n-1\n+0\n 2\n 3\n 4\n@@ -8,4 +8,4 @@\n 8\n 9\n 10\n-11\n+12\n"},
+		// Closer ones share it
+		{"1\n2\n3\n4\n5\n6\n7\n8\n", "0\n2\n3\n4\n5\n6\n7\n9\n",
+			"--- x.pie\n+++ x.pie (formatted)\n@@ -1,8 +1,8 @@\n-1\n+0\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+9\n"},
+	}
+
+	for _, tt := range tests {
+		if actual := Diff("x.pie", tt.before, tt.after); actual != tt.expected {
+			t.Errorf("Wrong diff of %q and %q. expected=%q, got=%q", tt.before, tt.after, tt.expected, actual)
+		}
+	}
+}
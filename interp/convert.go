@@ -0,0 +1,459 @@
+package interp
+
+import (
+	"cottagepie/evaluator"
+	"cottagepie/object"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Nested values deeper than this are most likely cycles, eg: a struct pointing to itself or
+// an array containing itself
+const MAX_CONVERSION_DEPTH = 100
+
+// The struct tag renaming a field in hashes, `pie:"-"` leaves the field out
+const STRUCT_TAG = "pie"
+
+var objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// FromGo converts a Go value to a CottagePie object: numbers, strings and booleans to their
+// object, slices and arrays to arrays, maps and structs to hashes, nil to null and functions
+// to built-ins like Register does. Objects are returned as they are
+func FromGo(value interface{}) (object.Object, error) {
+	if value == nil {
+		return evaluator.NULL, nil
+	}
+	return fromGo(reflect.ValueOf(value), 0)
+}
+
+func fromGo(value reflect.Value, depth int) (object.Object, error) {
+	if depth > MAX_CONVERSION_DEPTH {
+		return nil, tooDeep()
+	}
+
+	if !value.IsValid() {
+		return evaluator.NULL, nil
+	}
+
+	if value.Type().Implements(objectType) {
+		if value.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return value.Interface().(object.Object), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		if value.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: value.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d does not fit in an integer", value.Uint())
+		}
+		return &object.Integer{Value: int64(value.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: value.Float()}, nil
+
+	case reflect.String:
+		return &object.String{Value: value.String()}, nil
+
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return fromGo(value.Elem(), depth+1)
+
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return evaluator.NULL, nil
+		}
+
+		elements := make([]object.Object, value.Len())
+		for i := range elements {
+			element, err := fromGo(value.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if value.IsNil() {
+			return evaluator.NULL, nil
+		}
+
+		// Go maps have no order, sorting the keys keeps the hash the same every time
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+
+		hash := object.NewHash()
+		for _, key := range keys {
+			if err := setPair(hash, key, value.MapIndex(key), depth); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+
+	case reflect.Struct:
+		hash := object.NewHash()
+		for i := 0; i < value.NumField(); i++ {
+			name, ok := fieldName(value.Type().Field(i))
+			if !ok {
+				continue
+			}
+			if err := setPair(hash, reflect.ValueOf(name), value.Field(i), depth); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+
+	case reflect.Func:
+		if value.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return wrapFunc("recipe", value)
+
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a CottagePie value", value.Type())
+	}
+}
+
+func setPair(hash *object.Hash, key, value reflect.Value, depth int) error {
+	keyObj, err := fromGo(key, depth+1)
+	if err != nil {
+		return err
+	}
+
+	hashKey, ok := object.HashKeyOf(keyObj)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", keyObj.Type())
+	}
+
+	valueObj, err := fromGo(value, depth+1)
+	if err != nil {
+		return err
+	}
+
+	hash.Set(hashKey, object.HashPair{Key: object.FreezeKey(keyObj), Value: valueObj})
+	return nil
+}
+
+// lessKey orders map keys: numbers by value, strings and booleans like Go does and anything
+// else by how it prints. Keys of different kinds, in interface{} maps, are ordered by kind
+func lessKey(a, b reflect.Value) bool {
+	for a.Kind() == reflect.Interface || a.Kind() == reflect.Ptr {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface || b.Kind() == reflect.Ptr {
+		b = b.Elem()
+	}
+
+	if a.Kind() != b.Kind() {
+		return a.Kind() < b.Kind()
+	}
+
+	switch a.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	default:
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+}
+
+// fieldName returns the hash key of an exported struct field, its tag if it has one
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+
+	switch tag := field.Tag.Get(STRUCT_TAG); tag {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return tag, true
+	}
+}
+
+// ToGo stores obj in the value target points to, converting it like FromGo the other way
+// around. With a *interface{} target integers become int64, floats float64, arrays
+// []interface{} and hashes map[string]interface{}, or map[interface{}]interface{} when
+// some keys aren't strings. Other objects, like recipes, are stored as they are
+func ToGo(obj object.Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+
+	value, err := toGo(obj, ptr.Elem().Type(), 0)
+	if err != nil {
+		return err
+	}
+
+	ptr.Elem().Set(value)
+	return nil
+}
+
+func toGo(obj object.Object, typ reflect.Type, depth int) (reflect.Value, error) {
+	if depth > MAX_CONVERSION_DEPTH {
+		return reflect.Value{}, tooDeep()
+	}
+
+	if typ.Kind() == reflect.Interface && typ.NumMethod() == 0 {
+		return natural(obj, depth)
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(typ) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	if obj == evaluator.NULL {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(typ), nil
+		}
+	}
+
+	value := reflect.New(typ).Elem()
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		boolean, ok := obj.(*object.Boolean)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		value.SetBool(boolean.Value)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, ok := obj.(*object.Integer)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		if value.OverflowInt(integer.Value) {
+			return value, fmt.Errorf("%d overflows %s", integer.Value, typ)
+		}
+		value.SetInt(integer.Value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		integer, ok := obj.(*object.Integer)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		if integer.Value < 0 || value.OverflowUint(uint64(integer.Value)) {
+			return value, fmt.Errorf("%d overflows %s", integer.Value, typ)
+		}
+		value.SetUint(uint64(integer.Value))
+
+	case reflect.Float32, reflect.Float64:
+		switch number := obj.(type) {
+		case *object.Float:
+			value.SetFloat(number.Value)
+		case *object.Integer:
+			value.SetFloat(float64(number.Value))
+		default:
+			return value, mismatch(obj, typ)
+		}
+
+	case reflect.String:
+		str, ok := obj.(*object.String)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		value.SetString(str.Value)
+
+	case reflect.Ptr:
+		elem, err := toGo(obj, typ.Elem(), depth+1)
+		if err != nil {
+			return value, err
+		}
+		ptr := reflect.New(typ.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+
+	case reflect.Slice:
+		array, ok := obj.(*object.Array)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		value.Set(reflect.MakeSlice(typ, len(array.Elements), len(array.Elements)))
+		for i, element := range array.Elements {
+			converted, err := toGo(element, typ.Elem(), depth+1)
+			if err != nil {
+				return value, fmt.Errorf("index %d: %s", i, err)
+			}
+			value.Index(i).Set(converted)
+		}
+
+	case reflect.Array:
+		array, ok := obj.(*object.Array)
+		if !ok || len(array.Elements) != typ.Len() {
+			return value, mismatch(obj, typ)
+		}
+		for i, element := range array.Elements {
+			converted, err := toGo(element, typ.Elem(), depth+1)
+			if err != nil {
+				return value, fmt.Errorf("index %d: %s", i, err)
+			}
+			value.Index(i).Set(converted)
+		}
+
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		value.Set(reflect.MakeMapWithSize(typ, hash.Len()))
+		for _, pair := range hash.Pairs() {
+			key, err := toGo(pair.Key, typ.Key(), depth+1)
+			if err != nil {
+				return value, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+			converted, err := toGo(pair.Value, typ.Elem(), depth+1)
+			if err != nil {
+				return value, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+			value.SetMapIndex(key, converted)
+		}
+
+	case reflect.Struct:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return value, mismatch(obj, typ)
+		}
+		// Fields missing from the hash keep their zero value, extra keys are ignored
+		for i := 0; i < typ.NumField(); i++ {
+			name, ok := fieldName(typ.Field(i))
+			if !ok {
+				continue
+			}
+			key, _ := object.HashKeyOf(&object.String{Value: name})
+			pair, ok := hash.Get(key)
+			if !ok {
+				continue
+			}
+			converted, err := toGo(pair.Value, typ.Field(i).Type, depth+1)
+			if err != nil {
+				return value, fmt.Errorf("field %s: %s", name, err)
+			}
+			value.Field(i).Set(converted)
+		}
+
+	default:
+		return value, mismatch(obj, typ)
+	}
+
+	return value, nil
+}
+
+// natural converts an object to the Go type closest to it, for interface{} targets
+func natural(obj object.Object, depth int) (reflect.Value, error) {
+	if depth > MAX_CONVERSION_DEPTH {
+		return reflect.Value{}, tooDeep()
+	}
+
+	var result interface{}
+
+	switch obj := obj.(type) {
+	case *object.Null:
+		return reflect.Zero(reflect.TypeOf(&result).Elem()), nil
+	case *object.Integer:
+		result = obj.Value
+	case *object.Float:
+		result = obj.Value
+	case *object.String:
+		result = obj.Value
+	case *object.Boolean:
+		result = obj.Value
+
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := natural(element, depth+1)
+			if err != nil {
+				return value, err
+			}
+			elements[i] = value.Interface()
+		}
+		result = elements
+
+	case *object.Hash:
+		stringKeys := make(map[string]interface{}, obj.Len())
+		anyKeys := make(map[interface{}]interface{}, obj.Len())
+		onlyStrings := true
+
+		for _, pair := range obj.Pairs() {
+			value, err := natural(pair.Value, depth+1)
+			if err != nil {
+				return value, err
+			}
+			converted := value.Interface()
+
+			if key, ok := pair.Key.(*object.String); ok {
+				stringKeys[key.Value] = converted
+			} else {
+				onlyStrings = false
+			}
+			anyKeys[naturalKey(pair.Key)] = converted
+		}
+
+		if onlyStrings {
+			result = stringKeys
+		} else {
+			result = anyKeys
+		}
+
+	default:
+		result = obj
+	}
+
+	return reflect.ValueOf(&result).Elem(), nil
+}
+
+// naturalKey converts a hash key like natural, except arrays which become Go arrays as
+// slices can't be map keys
+func naturalKey(key object.Object) interface{} {
+	array, ok := key.(*object.Array)
+	if !ok {
+		value, _ := natural(key, 0)
+		return value.Interface()
+	}
+
+	value := reflect.New(reflect.ArrayOf(len(array.Elements), interfaceType)).Elem()
+	for i, element := range array.Elements {
+		if converted := naturalKey(element); converted != nil {
+			value.Index(i).Set(reflect.ValueOf(converted))
+		}
+	}
+	return value.Interface()
+}
+
+func tooDeep() error {
+	return fmt.Errorf("value nested more than %d levels deep, is it cyclic?", MAX_CONVERSION_DEPTH)
+}
+
+func mismatch(obj object.Object, typ reflect.Type) error {
+	return fmt.Errorf("cannot use %s as %s", obj.Type(), typ)
+}
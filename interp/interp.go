@@ -0,0 +1,217 @@
+// Package interp embeds CottagePie in Go programs: run source, expose Go functions as
+// built-ins, share globals and call recipes from Go
+package interp
+
+import (
+	"context"
+	"cottagepie/diagnostic"
+	"cottagepie/evaluator"
+	"cottagepie/lexer"
+	"cottagepie/object"
+	"cottagepie/parser"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Interpreter keeps its globals in a Cookbook, every Eval sees what earlier ones baked
+type Interpreter struct {
+	book   *object.Cookbook
+	limits object.Limits
+}
+
+func New() *Interpreter {
+	return &Interpreter{book: object.NewCookbook()}
+}
+
+// NewWithContext creates an interpreter whose programs do their I/O through ctx, eg: to capture plates
+func NewWithContext(ctx *object.Context) *Interpreter {
+	return &Interpreter{book: object.NewCookbookWithContext(ctx)}
+}
+
+// ParseError holds every diagnostic of a program that failed to parse
+type ParseError struct {
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	messages := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		messages[i] = d.String()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// RuntimeError wraps the error object a program or recipe call served, with its stack trace
+type RuntimeError struct {
+	Object *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	return strings.TrimPrefix(e.Object.Inspect(), "ERROR>> ")
+}
+
+// Book is the Cookbook holding the globals, eg: to run programs on it with the evaluator directly
+func (i *Interpreter) Book() *object.Cookbook {
+	return i.book
+}
+
+// SetLimits bounds every later Eval and Call, eg: to run untrusted programs. A program going
+// over a limit fails with a RuntimeError whose Object.Limit tells which one
+func (i *Interpreter) SetLimits(limits object.Limits) {
+	i.limits = limits
+}
+
+// Eval runs source and returns the value of its last statement, nil if it has none
+func (i *Interpreter) Eval(source string) (object.Object, error) {
+	return i.EvalContext(context.Background(), source)
+}
+
+// EvalContext runs source like Eval, stopping it once ctx is cancelled
+func (i *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	l := lexer.NewWithFile("<interp>", source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Diagnostics: p.Errors()}
+	}
+
+	return result(evaluator.EvalContext(ctx, program, i.book, i.limits))
+}
+
+// Register exposes a Go function as a built-in. Arguments are converted to the parameter
+// types with ToGo, object.Object parameters get the object as it is. The function can
+// return nothing, a value, an error or a value and an error; a non-nil error stops the
+// program like any other runtime error
+func (i *Interpreter) Register(name string, fn interface{}) error {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return fmt.Errorf("cannot register %s: %T is not a function", name, fn)
+	}
+
+	built_in, err := wrapFunc(name, value)
+	if err != nil {
+		return fmt.Errorf("cannot register %s: %s", name, err)
+	}
+
+	i.book.Set(name, built_in)
+	return nil
+}
+
+// Set bakes a global, converting the value with FromGo
+func (i *Interpreter) Set(name string, value interface{}) error {
+	obj, err := FromGo(value)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %s", name, err)
+	}
+
+	i.book.Set(name, obj)
+	return nil
+}
+
+// Get returns a global, use ToGo to convert it
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.book.Get(name)
+}
+
+// Call calls the recipe or built-in baked under name, converting the arguments with FromGo
+func (i *Interpreter) Call(name string, args ...interface{}) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext calls a recipe like Call, stopping it once ctx is cancelled
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (object.Object, error) {
+	rc, ok := i.book.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("no recipe named %s", name)
+	}
+
+	objects := make([]object.Object, len(args))
+	for idx, arg := range args {
+		obj, err := FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %s", idx+1, name, err)
+		}
+		objects[idx] = obj
+	}
+
+	end := i.book.Context().Begin(ctx, i.limits)
+	defer end()
+
+	return result(evaluator.ApplyRecipe(i.book.Context(), rc, objects))
+}
+
+func result(obj object.Object) (object.Object, error) {
+	if err, ok := obj.(*object.Error); ok {
+		return nil, &RuntimeError{Object: err}
+	}
+	return obj, nil
+}
+
+// wrapFunc builds a built-in calling fn, checking its signature once so calls only convert values
+func wrapFunc(name string, fn reflect.Value) (*object.BuiltIn, error) {
+	typ := fn.Type()
+
+	returnsError := typ.NumOut() > 0 && typ.Out(typ.NumOut()-1) == errorType
+	values := typ.NumOut()
+	if returnsError {
+		values--
+	}
+	if values > 1 {
+		return nil, fmt.Errorf("functions can return at most a value and an error, %s returns %d values", typ, typ.NumOut())
+	}
+
+	min, max := typ.NumIn(), typ.NumIn()
+	if typ.IsVariadic() {
+		min, max = min-1, -1
+	}
+
+	return &object.BuiltIn{
+		Fn: func(ctx *object.Context, args ...object.Object) (served object.Object) {
+			if err := evaluator.CheckArity(name, min, max, len(args)); err != nil {
+				return err
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					served = &object.Error{Message: fmt.Sprintf("`%s` panicked: %v", name, r)}
+				}
+			}()
+
+			in := make([]reflect.Value, len(args))
+			for idx, arg := range args {
+				paramType := parameterType(typ, idx)
+				converted, err := toGo(arg, paramType, 0)
+				if err != nil {
+					return &object.Error{Message: fmt.Sprintf("Argument %d to `%s`: %s", idx+1, name, err)}
+				}
+				in[idx] = converted
+			}
+
+			out := fn.Call(in)
+
+			if returnsError {
+				if err := out[len(out)-1]; !err.IsNil() {
+					return &object.Error{Message: err.Interface().(error).Error()}
+				}
+			}
+			if values == 0 {
+				return evaluator.NULL
+			}
+
+			obj, err := fromGo(out[0], 0)
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("Result of `%s`: %s", name, err)}
+			}
+			return obj
+		},
+	}, nil
+}
+
+func parameterType(typ reflect.Type, idx int) reflect.Type {
+	if typ.IsVariadic() && idx >= typ.NumIn()-1 {
+		return typ.In(typ.NumIn() - 1).Elem()
+	}
+	return typ.In(idx)
+}
@@ -0,0 +1,365 @@
+package interp
+
+import (
+	"context"
+	"cottagepie/object"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ingredient struct {
+	Name     string
+	Grams    int     `pie:"grams"`
+	Price    float64 `pie:"-"`
+	Optional *bool
+	secret   string
+}
+
+// tree is a slice type that can hold itself, to convert cyclic arrays without interface{}
+type tree []tree
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{"bake x to 2; x * 21", "42", ""},
+		{"1 +", "", "<interp>:1:4"},
+		{"1 + true", "", "<interp>:1:3: Type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		result, err := New().Eval(tt.input)
+
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("wrong error for %q. want prefix=%q, got=%v", tt.input, tt.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	interp := New()
+
+	_, err := interp.Eval("bake to")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Diagnostics) == 0 {
+		t.Errorf("parse failures should give a ParseError with diagnostics, got=%#v", err)
+	}
+
+	_, err = interp.Eval("bake f to rc() { 1 / 0 };\nf()")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("runtime failures should give a RuntimeError, got=%#v", err)
+	}
+	if runtimeErr.Object.StackTrace() == "" {
+		t.Errorf("RuntimeError lost the stack trace of %s", runtimeErr.Object.Inspect())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	interp := New()
+
+	registered := map[string]interface{}{
+		"add":   func(a, b int) int { return a + b },
+		"join":  func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"half":  func(f float64) float64 { return f / 2 },
+		"kind":  func(obj object.Object) string { return string(obj.Type()) },
+		"noop":  func() {},
+		"check": func(ok bool) error { return map[bool]error{true: nil, false: errors.New("check failed")}[ok] },
+		"weigh": func(i ingredient) (int, error) { return i.Grams, nil },
+		"stock": func() []ingredient { return []ingredient{{Name: "flour", Grams: 500}} },
+		"boom":  func() int { panic("too hot") },
+		"sum": func(numbers []int) (total int) {
+			for _, n := range numbers {
+				total += n
+			}
+			return total
+		},
+		"anyway": func(v interface{}) interface{} { return v },
+	}
+	for name, fn := range registered {
+		if err := interp.Register(name, fn); err != nil {
+			t.Fatalf("register %s: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(40, 2)", "42"},
+		{`join("-", "a", "b", "c")`, "a-b-c"},
+		{`join(",")`, ""},
+		{"half(3)", "1.5"},
+		{"kind([1])", "ARRAY"},
+		{"noop()", "null"},
+		{"check(true)", "null"},
+		{"check(false)", "ERROR>> <interp>:1:6: check failed"},
+		{`weigh({"Name": "sugar", "grams": 200})`, "200"},
+		{`stock()[0]["grams"]`, "500"},
+		{`stock()[0]["Price"]`, "null"},
+		{"boom()", "ERROR>> <interp>:1:5: `boom` panicked: too hot"},
+		{"sum([1, 2, 3])", "6"},
+		{"anyway([1, noop()])", "[1, null]"},
+		{"add(1)", "ERROR>> <interp>:1:4: Wrong number of arguments to `add`, got=1, want=2"},
+		{`add(1, "2")`, "ERROR>> <interp>:1:4: Argument 2 to `add`: cannot use STRING as int"},
+		{"half(int)", "ERROR>> <interp>:1:5: Argument 1 to `half`: cannot use BUILT_IN as float64"},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Eval(tt.input)
+		actual := ""
+		if err != nil {
+			actual = "ERROR>> " + err.Error()
+		} else {
+			actual = result.Inspect()
+		}
+
+		if actual != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, actual)
+		}
+	}
+
+	if err := interp.Register("bad", 5); err == nil {
+		t.Errorf("registering a non function should fail")
+	}
+	if err := interp.Register("bad", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("registering a function with two results should fail")
+	}
+}
+
+func TestSetGetCall(t *testing.T) {
+	interp := New()
+
+	if err := interp.Set("pantry", map[string]int{"eggs": 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interp.Eval(`bake use to rc(item, count = 1) { pantry[item] - count }; bake left to use("eggs")`); err != nil {
+		t.Fatal(err)
+	}
+
+	left, ok := interp.Get("left")
+	if !ok || left.Inspect() != "5" {
+		t.Errorf("wrong global left. got=%v", left)
+	}
+
+	result, err := interp.Call("use", "eggs", 4)
+	if err != nil || result.Inspect() != "2" {
+		t.Errorf("wrong call result. got=%v, %v", result, err)
+	}
+
+	if _, err := interp.Call("missing"); err == nil {
+		t.Errorf("calling an unknown recipe should fail")
+	}
+	if _, err := interp.Call("use"); err == nil || !strings.Contains(err.Error(), "Wrong number of arguments") {
+		t.Errorf("wrong arity error. got=%v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	interp := New()
+	interp.SetLimits(object.Limits{MaxSteps: 10000})
+
+	if _, err := interp.Eval("recipe spin() { while (true) {} }"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := interp.Call("spin")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Object.Limit != object.STEP_LIMIT {
+		t.Errorf("spin should go over the step limit, got=%v", err)
+	}
+
+	// A Go built-in calling back into the program stays within the limits of the run
+	if _, err := interp.Eval("recipe count() { bake i to 0; while (i < 500) { i += 1 } }"); err != nil {
+		t.Fatal(err)
+	}
+	err = interp.Register("nested", func() error {
+		_, err := interp.Call("count")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := interp.Eval("nested()"); err != nil {
+		t.Fatalf("a single nested call should fit in the limits, got=%v", err)
+	}
+	_, err = interp.Eval("bake n to 0; while (n < 100) { nested(); n += 1 }")
+	if err == nil || !strings.Contains(err.Error(), "Step limit exceeded") {
+		t.Errorf("nested calls should count against the step limit of the run, got=%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	interp.SetLimits(object.Limits{})
+
+	_, err = interp.EvalContext(ctx, "spin()")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Object.Limit != object.TIMEOUT {
+		t.Errorf("spin should time out, got=%v", err)
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	yes := true
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{int8(-3), "-3"},
+		{uint16(7), "7"},
+		{float32(0.5), "0.5"},
+		{"pie", "pie"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[int]string{1: "one"}, `{1: one}`},
+		{ingredient{Name: "salt", Grams: 5, Optional: &yes, secret: "x"}, ""},
+		{&object.Integer{Value: 9}, "9"},
+		{[]interface{}{nil, 1.0}, "[null, 1.0]"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) failed: %s", tt.input, err)
+			continue
+		}
+
+		if hash, ok := obj.(*object.Hash); ok && tt.expected == "" {
+			var back map[string]interface{}
+			if err := ToGo(hash, &back); err != nil {
+				t.Fatal(err)
+			}
+			expected := map[string]interface{}{"Name": "salt", "grams": int64(5), "Optional": true}
+			if !reflect.DeepEqual(back, expected) {
+				t.Errorf("wrong struct hash. want=%v, got=%v", expected, back)
+			}
+			continue
+		}
+
+		if obj.Inspect() != tt.expected {
+			t.Errorf("wrong conversion of %#v. want=%s, got=%s", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	// Map keys are sorted, so converting the same map always gives the same hash
+	menu := map[interface{}]int{"scone": 1, "bun": 2, 10: 3, -2: 4, 2.5: 5, true: 6}
+	for i := 0; i < 20; i++ {
+		obj, err := FromGo(menu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "{true: 6, -2: 4, 10: 3, 2.5: 5, bun: 2, scone: 1}"
+		if obj.Inspect() != expected {
+			t.Fatalf("wrong map order. want=%s, got=%s", expected, obj.Inspect())
+		}
+	}
+
+	type node struct{ Next *node }
+	cyclic := &node{}
+	cyclic.Next = cyclic
+
+	failures := []interface{}{uint64(math.MaxUint64), make(chan int), cyclic, map[string]chan int{"c": nil}}
+	for _, input := range failures {
+		if _, err := FromGo(input); err == nil {
+			t.Errorf("FromGo(%T) should fail", input)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	interp := New()
+	obj, err := interp.Eval(`{"Name": "butter", "grams": 250, "Price": 3, "Optional": false, "extra": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grid, err := interp.Eval(`{[0, 1]: "a", [1, 0]: "b"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cells map[[2]int]string
+	if err := ToGo(grid, &cells); err != nil || cells[[2]int{1, 0}] != "b" {
+		t.Errorf("wrong grid conversion. got=%v, %v", cells, err)
+	}
+	back, err := FromGo(cells)
+	if err != nil || !back.Equal(grid) {
+		t.Errorf("grid did not convert back. got=%v, %v", back, err)
+	}
+
+	var item ingredient
+	if err := ToGo(obj, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "butter" || item.Grams != 250 || item.Price != 0 || item.Optional == nil || *item.Optional {
+		t.Errorf("wrong struct. got=%+v", item)
+	}
+
+	natural := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"5", int64(5)},
+		{"2.5", 2.5},
+		{`"pie"`, "pie"},
+		{"if (false) { 1 }", nil},
+		{`[1, "a", [true]]`, []interface{}{int64(1), "a", []interface{}{true}}},
+		{`{1: "a"}`, map[interface{}]interface{}{int64(1): "a"}},
+		{`{[1, [true]]: "a"}`, map[interface{}]interface{}{[2]interface{}{int64(1), [1]interface{}{true}}: "a"}},
+	}
+
+	for _, tt := range natural {
+		obj, err := interp.Eval(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actual interface{}
+		if err := ToGo(obj, &actual); err != nil {
+			t.Errorf("ToGo(%s) failed: %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("wrong conversion of %s. want=%#v, got=%#v", tt.input, tt.expected, actual)
+		}
+	}
+
+	failures := []struct {
+		input  string
+		target interface{}
+	}{
+		{"300", new(int8)},
+		{"-1", new(uint)},
+		{"1.5", new(int)},
+		{`[1, "a"]`, new([]int)},
+		{"1", 5},
+		{"bake a to [0]; a[0] = a; a", new(interface{})},
+		{"bake a to [0]; a[0] = a; a", new(tree)},
+		{`bake h to {"k": 0}; h["k"] = h; h`, new(interface{})},
+		{`bake h to {"k": 0}; h["k"] = h; h`, new(map[string]interface{})},
+	}
+
+	for _, tt := range failures {
+		obj, _ := interp.Eval(tt.input)
+		if err := ToGo(obj, tt.target); err == nil {
+			t.Errorf("ToGo(%s, %T) should fail", tt.input, tt.target)
+		}
+	}
+}
@@ -1,23 +1,51 @@
 package lexer
 
 import (
+	"cottagepie/diagnostic"
 	"cottagepie/token"
 )
 
 type Lexer struct {
 	input        string
+	file         string
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+	line         int  // line of the current char
+	column       int  // column of the current char
+	errors       []diagnostic.Diagnostic
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFile("", input)
+}
+
+// NewWithFile creates a lexer whose token positions refer to the given file name
+func NewWithFile(file string, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
+// skipShebang ignores a leading "#!/usr/bin/env cottagepie" line so scripts can be executed directly
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	l.column += 1
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -30,7 +58,8 @@ func (l *Lexer) readChar() {
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
-	l.skipWhitespace()
+	comments := l.skipTrivia()
+	pos := l.currentPosition()
 
 	switch l.ch {
 	case '=':
@@ -43,9 +72,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newOperatorToken(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newOperatorToken(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -56,9 +85,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.newOperatorToken(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.newOperatorToken(token.ASTERISK, token.ASTERISK_ASSIGN)
+	case '%':
+		tok = l.newOperatorToken(token.PERCENT, token.PERCENT_ASSIGN)
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
@@ -87,6 +118,14 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.peekCharAt(2) == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -95,10 +134,13 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
+			tok.Comments = comments
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
+			tok.Pos = pos
+			tok.Comments = comments
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -106,9 +148,30 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos = pos
+	tok.Comments = comments
 	return tok
 }
 
+// Errors returns the diagnostics found while reading the input, eg: unterminated comments
+func (l *Lexer) Errors() []diagnostic.Diagnostic {
+	return l.errors
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column}
+}
+
+// newOperatorToken reads an operator which becomes a compound assignment when followed by '=', eg: +=
+func (l *Lexer) newOperatorToken(operator token.TokenType, assignment token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: assignment, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(operator, l.ch)
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
@@ -131,12 +194,101 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) readNumber() string {
+// skipTrivia skips whitespace and comments, returning the comments so they can be kept on the next token
+func (l *Lexer) skipTrivia() []token.Comment {
+	var comments []token.Comment
+
+	for {
+		l.skipWhitespace()
+
+		if l.ch == '/' && l.peekChar() == '/' {
+			comments = append(comments, l.readLineComment())
+		} else if l.ch == '/' && l.peekChar() == '*' {
+			comments = append(comments, l.readBlockComment())
+		} else {
+			return comments
+		}
+	}
+}
+
+func (l *Lexer) readLineComment() token.Comment {
+	pos := l.currentPosition()
 	position := l.position
+
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+
+	return token.Comment{Text: l.input[position:l.position], Pos: pos}
+}
+
+// readBlockComment reads a /* ... */ comment, block comments can be nested
+func (l *Lexer) readBlockComment() token.Comment {
+	pos := l.currentPosition()
+	position := l.position
+	depth := 0
+
+	for {
+		if l.ch == 0 {
+			l.errors = append(l.errors, diagnostic.Diagnostic{
+				Code:    diagnostic.UNTERMINATED_COMMENT,
+				Message: "Unterminated block comment",
+				Start:   pos,
+				End:     l.currentPosition(),
+				Hint:    "Block comments are closed with */, nested /* need their own */",
+			})
+			break
+		}
+
+		if l.ch == '/' && l.peekChar() == '*' {
+			depth += 1
+			l.readChar()
+		} else if l.ch == '*' && l.peekChar() == '/' {
+			depth -= 1
+			l.readChar()
+		}
+		l.readChar()
+
+		if depth == 0 {
+			break
+		}
+	}
+
+	return token.Comment{Text: l.input[position:l.position], Pos: pos}
+}
+
+// readNumber reads an integer, or a float when it has a fraction and/or an exponent: 1.5, 2e10, 1.5e-3
+func (l *Lexer) readNumber() (token.TokenType, string) {
+	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(2))) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 func isDigit(ch byte) bool {
@@ -151,12 +303,31 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// peekCharAt looks ahead n chars past the current one, peekCharAt(1) is peekChar()
+func (l *Lexer) peekCharAt(n int) byte {
+	if l.position+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.position+n]
+}
+
 func (l *Lexer) readString(end_char byte) string {
+	pos := l.currentPosition()
 	position := l.position + 1
 
 	for {
 		l.readChar()
-		if l.ch == end_char || l.ch == 0 {
+		if l.ch == end_char {
+			break
+		}
+		if l.ch == 0 {
+			l.errors = append(l.errors, diagnostic.Diagnostic{
+				Code:    diagnostic.UNTERMINATED_STRING,
+				Message: "Unterminated string",
+				Start:   pos,
+				End:     l.currentPosition(),
+				Hint:    "Strings are closed with the quote they were opened with",
+			})
 			break
 		}
 	}
@@ -3,6 +3,7 @@ package lexer
 import (
 	"testing"
 
+	"cottagepie/diagnostic"
 	"cottagepie/token"
 )
 
@@ -15,7 +16,7 @@ func TestNextToken(t *testing.T) {
 		};
 		
 		bake result to add(five, ten);
-		!-/*5;
+		!-/ *5 % 2;
 		5 < 10 > 5;
 		
 		if (5 < 10) {
@@ -32,6 +33,10 @@ func TestNextToken(t *testing.T) {
 		'Cristiano Ronaldo'
 		[1, 2];
 		{"goat": "Cristiano"}
+		rc(...rest)
+		1.5 2e10 3.25E-2 7.e
+		while for in break continue
+		x += 1 -= 2 *= 3 /= 4 %= 5
 	`
 
 	tests := []struct {
@@ -83,6 +88,8 @@ func TestNextToken(t *testing.T) {
 		{token.SLASH, "/"},
 		{token.ASTERISK, "*"},
 		{token.INT, "5"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
 		{token.SEMICOLON, ";"},
 
 		{token.INT, "5"},
@@ -138,6 +145,37 @@ func TestNextToken(t *testing.T) {
 		{token.STRING, "Cristiano"},
 		{token.RBRACE, "}"},
 
+		{token.RECIPE, "rc"},
+		{token.LPAREN, "("},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "rest"},
+		{token.RPAREN, ")"},
+
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "2e10"},
+		{token.FLOAT, "3.25E-2"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "e"},
+
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.INT, "5"},
+
 		{token.EOF, ""},
 	}
 
@@ -155,3 +193,151 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `bake x to 5;
+	x + "ten";`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.BAKE, 1, 1},
+		{token.IDENT, 1, 6},
+		{token.ASSIGN, 1, 8},
+		{token.INT, 1, 11},
+		{token.SEMICOLON, 1, 12},
+		{token.IDENT, 2, 2},
+		{token.PLUS, 2, 4},
+		{token.STRING, 2, 6},
+		{token.SEMICOLON, 2, 11},
+		{token.EOF, 2, 12},
+	}
+
+	l := NewWithFile("main.pie", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.File != "main.pie" {
+			t.Fatalf("tests[%d] - file wrong. expected=%q, got=%q", i, "main.pie", tok.Pos.File)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// a line comment
+bake x to 5; // trailing
+/* a block
+   comment */ x / /* nested /* block */ comment */ 2;`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.BAKE, "bake", []string{"// a line comment"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "to", nil},
+		{token.INT, "5", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing", "/* a block\n   comment */"}},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", []string{"/* nested /* block */ comment */"}},
+		{token.SEMICOLON, ";", nil},
+		{token.EOF, "", nil},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("tests[%d] - wrong number of comments. expected=%d, got=%d", i, len(tt.expectedComments), len(tok.Comments))
+		}
+
+		for j, comment := range tt.expectedComments {
+			if tok.Comments[j].Text != comment {
+				t.Fatalf("tests[%d] - comment wrong. expected=%q, got=%q", i, comment, tok.Comments[j].Text)
+			}
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("lexer has unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("5;\n /* never /* closed */")
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("Expected 1 lexer error, got=%d", len(errors))
+	}
+
+	expected := "2:2: Unterminated block comment"
+	if errors[0].String() != expected {
+		t.Fatalf("Wrong error message. expected=%q, got=%q", expected, errors[0])
+	}
+
+	if errors[0].Code != diagnostic.UNTERMINATED_COMMENT {
+		t.Fatalf("Wrong error code. expected=%q, got=%q", diagnostic.UNTERMINATED_COMMENT, errors[0].Code)
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := New("5;\n bake s to 'pie;")
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("Expected 1 lexer error, got=%d", len(errors))
+	}
+
+	expected := "2:12: Unterminated string"
+	if errors[0].String() != expected {
+		t.Fatalf("Wrong error message. expected=%q, got=%q", expected, errors[0])
+	}
+
+	if errors[0].Code != diagnostic.UNTERMINATED_STRING {
+		t.Fatalf("Wrong error code. expected=%q, got=%q", diagnostic.UNTERMINATED_STRING, errors[0].Code)
+	}
+}
+
+func TestShebang(t *testing.T) {
+	l := New("#!/usr/bin/env cottagepie\nbake x to 5;")
+
+	tok := l.NextToken()
+	if tok.Type != token.BAKE {
+		t.Fatalf("tokenType wrong. expected=%q, got=%q", token.BAKE, tok.Type)
+	}
+
+	if tok.Pos.Line != 2 || tok.Pos.Column != 1 {
+		t.Fatalf("position wrong. expected=2:1, got=%s", tok.Pos)
+	}
+}
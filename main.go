@@ -1,19 +1,168 @@
 package main
 
 import (
+	"cottagepie/diagnostic"
+	"cottagepie/engine"
+	"cottagepie/evaluator"
+	"cottagepie/lexer"
+	"cottagepie/object"
+	"cottagepie/parser"
 	"cottagepie/repl"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 )
 
+const (
+	EXIT_OK            = 0
+	EXIT_RUNTIME_ERROR = 1
+	EXIT_UNFORMATTED   = 1 // fmt --check found files that aren't formatted
+	EXIT_PARSE_ERROR   = 2
+	EXIT_USAGE         = 64
+)
+
+const USAGE = `Usage:
+  cottagepie                  start the REPL, or run the program piped into stdin
+  cottagepie run file.pie     run a script file
+  cottagepie file.pie         same as run, so scripts can start with #!/usr/bin/env cottagepie
+  cottagepie -e 'program'     run the given program and print its result
+  cottagepie fmt [path ...]   format files, or the directories' .pie files, in place (stdin to stdout without paths)
+
+Options:
+  -engine eval|vm             run programs with the tree-walking evaluator (default) or the bytecode vm
+
+Options of fmt:
+  --check                     list the files that aren't formatted and exit with status 1 if any, without changing them
+  --diff                      show the changes formatting would make instead of making them
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("cottagepie", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, USAGE) }
+	expression := flags.String("e", "", "run the given program and print its result")
+	engineName := flags.String("engine", engine.EVALUATOR, "eval or vm")
+
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	args = flags.Args()
+
+	ctx := object.NewContext(stdin, stdout, stderr)
+	eng, err := engine.NewWithContext(*engineName, ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_USAGE
+	}
+
+	switch {
+	case *expression != "":
+		return runSource(eng, "<expr>", *expression, stdout, stderr, true)
+
+	case len(args) > 0 && args[0] == "fmt":
+		return formatCommand(args[1:], stdin, stdout, stderr)
+
+	case len(args) > 0 && args[0] == "run":
+		if len(args) != 2 {
+			flags.Usage()
+			return EXIT_USAGE
+		}
+		return runFile(eng, args[1], stdout, stderr)
+
+	case len(args) == 1:
+		return runFile(eng, args[0], stdout, stderr)
+
+	case len(args) > 1:
+		flags.Usage()
+		return EXIT_USAGE
+
+	case isTerminal(stdin):
+		startRepl(eng, ctx, stdin.(*os.File))
+		return EXIT_OK
+
+	default:
+		source, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not read stdin: %s\n", err)
+			return EXIT_USAGE
+		}
+		return runSource(eng, "<stdin>", string(source), stdout, stderr, false)
+	}
+}
+
+func startRepl(eng engine.Engine, ctx *object.Context, stdin *os.File) {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("Hello %s ! This is the CottagePie programming language !\n", user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	fmt.Fprintf(ctx.Stdout, "Hello %s ! This is the CottagePie programming language !\n", user.Username)
+	fmt.Fprintf(ctx.Stdout, "Feel free to type in commands\n")
+	repl.StartTerminal(ctx, eng, stdin)
+}
+
+func runFile(eng engine.Engine, path string, stdout, stderr io.Writer) int {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read file: %s\n", err)
+		return EXIT_USAGE
+	}
+
+	return runSource(eng, path, string(source), stdout, stderr, false)
+}
+
+// runSource evaluates a whole program and returns the process exit code
+func runSource(eng engine.Engine, name string, source string, stdout, stderr io.Writer, printResult bool) int {
+	l := lexer.NewWithFile(name, source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printDiagnostics(stderr, p.Errors())
+		return EXIT_PARSE_ERROR
+	}
+
+	result := eng.Run(program)
+
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintln(stderr, err.Inspect())
+		fmt.Fprint(stderr, err.StackTrace())
+		return EXIT_RUNTIME_ERROR
+	}
+
+	if printResult && result != nil && result != evaluator.NULL {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+
+	return EXIT_OK
+}
+
+func printDiagnostics(stderr io.Writer, errors []diagnostic.Diagnostic) {
+	for _, err := range errors {
+		fmt.Fprintf(stderr, "%s [%s]\n", err, err.Code)
+		if err.Hint != "" {
+			fmt.Fprintf(stderr, "  hint: %s\n", err.Hint)
+		}
+	}
+}
+
+// isTerminal tells if r is a terminal, the REPL only starts when stdin is one
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"hello.pie":   `plates("hello")`,
+		"shebang.pie": "#!/usr/bin/env cottagepie\nplates(\"hi\")\n",
+		"broken.pie":  "plates(1 +)",
+		"failing.pie": "plates(1 + true)",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string // contained in stderr, empty when nothing should be written
+	}{
+		{[]string{"run", filepath.Join(dir, "hello.pie")}, "", EXIT_OK, "hello\n", ""},
+		{[]string{filepath.Join(dir, "hello.pie")}, "", EXIT_OK, "hello\n", ""},
+		{[]string{filepath.Join(dir, "shebang.pie")}, "", EXIT_OK, "hi\n", ""},
+		{[]string{"-engine", "vm", filepath.Join(dir, "shebang.pie")}, "", EXIT_OK, "hi\n", ""},
+		{[]string{"-engine", "vm", "run", filepath.Join(dir, "failing.pie")}, "", EXIT_RUNTIME_ERROR, "", "ERROR>> " + filepath.Join(dir, "failing.pie") + ":1:10: Type mismatch: INTEGER + BOOLEAN"},
+		{[]string{"run", filepath.Join(dir, "broken.pie")}, "", EXIT_PARSE_ERROR, "", "broken.pie:1:11: No prefix parse function for ) found"},
+		{[]string{"run", filepath.Join(dir, "failing.pie")}, "", EXIT_RUNTIME_ERROR, "", "ERROR>> " + filepath.Join(dir, "failing.pie") + ":1:10: Type mismatch: INTEGER + BOOLEAN"},
+		{[]string{"run", filepath.Join(dir, "missing.pie")}, "", EXIT_USAGE, "", "Could not read file"},
+		{[]string{"-e", "1 + 2"}, "", EXIT_OK, "3\n", ""},
+		{[]string{"-e", `"a" + "b"`}, "", EXIT_OK, "ab\n", ""},
+		{[]string{"-e", "bake x to 1;"}, "", EXIT_OK, "", ""},
+		{[]string{"-e", `plates("a")`}, "", EXIT_OK, "a\n", ""},
+		{[]string{"-engine", "vm", "-e", `platef("%d pies", 3)`}, "", EXIT_OK, "3 pies\n", ""},
+		{[]string{"-engine", "vm", "-e", "rc(x) { x * 2 }(21)"}, "", EXIT_OK, "42\n", ""},
+		{[]string{"-engine", "eval", "-e", "rc(x) { x * 2 }(21)"}, "", EXIT_OK, "42\n", ""},
+		{[]string{"-e", "1 +"}, "", EXIT_PARSE_ERROR, "", "<expr>:1:"},
+		{[]string{"-e", "1 + true"}, "", EXIT_RUNTIME_ERROR, "", "ERROR>> <expr>:1:3: Type mismatch: INTEGER + BOOLEAN"},
+		{nil, `plates("from stdin"); 5`, EXIT_OK, "from stdin\n", ""},
+		{nil, "plates(1 + true)", EXIT_RUNTIME_ERROR, "", "<stdin>:1:10: Type mismatch"},
+		{nil, "plates(", EXIT_PARSE_ERROR, "", "<stdin>:1:"},
+		{[]string{"fmt"}, "bake  x to 1", EXIT_OK, "bake x to 1;\n", ""},
+		{[]string{"fmt", "--check"}, "bake  x to 1", EXIT_UNFORMATTED, "<stdin>\n", ""},
+		{[]string{"-x"}, "", EXIT_USAGE, "", "Usage:"},
+		{[]string{"-engine", "nope", "-e", "1"}, "", EXIT_USAGE, "", `unknown engine "nope"`},
+		{[]string{"run"}, "", EXIT_USAGE, "", "Usage:"},
+		{[]string{"a.pie", "b.pie"}, "", EXIT_USAGE, "", "Usage:"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		if code != tt.code {
+			t.Errorf("wrong exit code for %q. want=%d, got=%d (stderr: %s)", tt.args, tt.code, code, stderr.String())
+		}
+		if stdout.String() != tt.stdout {
+			t.Errorf("wrong stdout for %q. want=%q, got=%q", tt.args, tt.stdout, stdout.String())
+		}
+		if tt.stderr == "" && stderr.Len() != 0 || !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("wrong stderr for %q. want=%q, got=%q", tt.args, tt.stderr, stderr.String())
+		}
+	}
+}
+
+func TestFormatCommand(t *testing.T) {
+	const unformatted = "bake  x to 1\nplates(x)"
+	const formatted = "bake x to 1;\nplates(x);\n"
+
+	tests := []struct {
+		args   []string // "{dir}" is replaced by a directory holding the files below
+		stdin  string
+		code   int
+		stdout string
+		stderr string // contained in stderr, empty when nothing should be written
+		after  string // the content of ugly.pie once the command ran
+	}{
+		{nil, unformatted, EXIT_OK, formatted, "", unformatted},
+		{[]string{"--check"}, unformatted, EXIT_UNFORMATTED, "<stdin>\n", "", unformatted},
+		{[]string{"--check"}, formatted, EXIT_OK, "", "", unformatted},
+		{nil, "plates(1 +)", EXIT_PARSE_ERROR, "", "<stdin>:1:11: No prefix parse function for ) found", unformatted},
+		{[]string{"{dir}/ugly.pie"}, "", EXIT_OK, "", "", formatted},
+		{[]string{"{dir}"}, "", EXIT_OK, "", "", formatted},
+		{[]string{"--check", "{dir}/ugly.pie", "{dir}/pretty.pie"}, "", EXIT_UNFORMATTED, "{dir}/ugly.pie\n", "", unformatted},
+		{[]string{"--check", "{dir}/pretty.pie"}, "", EXIT_OK, "", "", unformatted},
+		{[]string{"--diff", "{dir}/ugly.pie"}, "", EXIT_OK, "--- {dir}/ugly.pie\n+++ {dir}/ugly.pie (formatted)\n" +
+			"@@ -1,2 +1,2 @@\n-bake  x to 1\n-plates(x)\n\\ No newline at end of file\n+bake x to 1;\n+plates(x);\n", "", unformatted},
+		{[]string{"--check", "{dir}"}, "", EXIT_PARSE_ERROR, "{dir}/ugly.pie\n", "broken.pie:1:11:", unformatted},
+		{[]string{"{dir}/missing.pie"}, "", EXIT_USAGE, "", "Could not read file", unformatted},
+		{[]string{"--nope"}, "", EXIT_USAGE, "", "Usage:", unformatted},
+	}
+
+	for _, tt := range tests {
+		dir := testDir(t, map[string]string{
+			"ugly.pie":       unformatted,
+			"pretty.pie":     formatted,
+			"notes.txt":      unformatted,
+			"sub/broken.pie": "plates(1 +)",
+		})
+		if len(tt.args) == 1 && tt.args[0] == "{dir}" {
+			// Formatting the whole directory needs every .pie file in it to parse
+			os.Remove(filepath.Join(dir, "sub", "broken.pie"))
+		}
+
+		args := make([]string, len(tt.args))
+		for i, arg := range tt.args {
+			args[i] = strings.Replace(arg, "{dir}", dir, -1)
+		}
+
+		var stdout, stderr bytes.Buffer
+		code := formatCommand(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		expectedStdout := strings.Replace(tt.stdout, "{dir}", dir, -1)
+
+		if code != tt.code {
+			t.Errorf("wrong exit code for %q. want=%d, got=%d (stderr: %s)", tt.args, tt.code, code, stderr.String())
+		}
+		if stdout.String() != expectedStdout {
+			t.Errorf("wrong stdout for %q. want=%q, got=%q", tt.args, expectedStdout, stdout.String())
+		}
+		if tt.stderr == "" && stderr.Len() != 0 || !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("wrong stderr for %q. want=%q, got=%q", tt.args, tt.stderr, stderr.String())
+		}
+
+		after, err := ioutil.ReadFile(filepath.Join(dir, "ugly.pie"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(after) != tt.after {
+			t.Errorf("wrong ugly.pie after %q. want=%q, got=%q", tt.args, tt.after, after)
+		}
+		if notes, _ := ioutil.ReadFile(filepath.Join(dir, "notes.txt")); string(notes) != unformatted {
+			t.Errorf("%q should only format .pie files, notes.txt became %q", tt.args, notes)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+// testDir creates a temporary directory holding files, the caller removes it
+func testDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "cottagepie")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
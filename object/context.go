@@ -0,0 +1,221 @@
+package object
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Context is where a running program reads its input and writes its output, eg: for plates
+// and input, and what keeps it within its limits. Every cookbook extended from a root
+// cookbook shares its context
+type Context struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	stdin  *bufio.Reader
+
+	// The limits of the current run and how much of them it used
+	limits Limits
+	done   <-chan struct{}
+	cause  func() error
+	steps  int64
+	depth  int
+	memory int64
+	runs   int // nested Begin calls, only the outermost one sets and lifts the limits
+
+	caller Caller
+}
+
+// Caller calls a recipe for a built-in, eg: map calling the recipe it was given. Engines
+// that can't call recipes through the evaluator install their own while they run
+type Caller func(rc Object, args []Object) Object
+
+// SetCaller installs caller and returns the previous one, to be put back once the run is over
+func (c *Context) SetCaller(caller Caller) (previous Caller) {
+	previous, c.caller = c.caller, caller
+	return previous
+}
+
+// Caller returns the installed caller, nil when recipes are called by the evaluator
+func (c *Context) Caller() Caller {
+	return c.caller
+}
+
+func NewContext(stdin io.Reader, stdout, stderr io.Writer) *Context {
+	return newContext(bufio.NewReader(stdin), stdout, stderr)
+}
+
+func newContext(stdin *bufio.Reader, stdout, stderr io.Writer) *Context {
+	return &Context{Stdout: stdout, Stderr: stderr, stdin: stdin, limits: Limits{}.withDefaults()}
+}
+
+// The process stdin is buffered once, so every default context reads the same lines
+var processStdin = bufio.NewReader(os.Stdin)
+
+// DefaultContext reads from and writes to the process stdin, stdout and stderr
+func DefaultContext() *Context {
+	return newContext(processStdin, os.Stdout, os.Stderr)
+}
+
+// Stdin is the buffered stdin ReadLine reads from, for readers that need more than lines, eg: a
+// line editor reading keys. Reading through it keeps the bytes read ahead shared with ReadLine
+func (c *Context) Stdin() *bufio.Reader {
+	return c.stdin
+}
+
+// ReadLine returns the next line of stdin without its line ending, io.EOF once all lines were read
+func (c *Context) ReadLine() (string, error) {
+	line, err := c.stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// Limits bound what a run can use, zero values mean no limit
+type Limits struct {
+	MaxSteps  int64         // expressions evaluated, or instructions run by the vm
+	MaxDepth  int           // nested recipe calls, DEFAULT_MAX_DEPTH when zero
+	MaxMemory int64         // approximate bytes allocated over the whole run, see SizeOf
+	Timeout   time.Duration // wall clock time
+}
+
+// Deeper recursion than this could overflow the Go stack of the evaluator and crash the host
+const DEFAULT_MAX_DEPTH = 10000
+
+// Cancellation is checked every CANCEL_CHECK_INTERVAL steps, checking on every step is too slow
+const CANCEL_CHECK_INTERVAL = 1024
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxSteps <= 0 {
+		l.MaxSteps = math.MaxInt64
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DEFAULT_MAX_DEPTH
+	}
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = math.MaxInt64
+	}
+	return l
+}
+
+// Begin starts a run that stops when goCtx is cancelled or one of the limits is exceeded,
+// the returned end has to be called once the run is over to lift the limits. A run begun
+// during another, eg: by a Go built-in calling back into the program, is part of it: it
+// keeps counting against the same limits and its end lifts nothing
+func (c *Context) Begin(goCtx context.Context, limits Limits) (end func()) {
+	c.runs++
+	if c.runs > 1 {
+		return func() { c.runs-- }
+	}
+
+	cancel := func() {}
+	if limits.Timeout > 0 {
+		goCtx, cancel = context.WithTimeout(goCtx, limits.Timeout)
+	}
+
+	c.limits = limits.withDefaults()
+	c.done = goCtx.Done()
+	c.cause = goCtx.Err
+	c.steps, c.depth, c.memory = 0, 0, 0
+
+	return func() {
+		cancel()
+		c.runs--
+		c.limits = Limits{}.withDefaults()
+		c.done, c.cause = nil, nil
+	}
+}
+
+// Step counts one evaluation step, it fails once the run used all of its steps or was cancelled
+func (c *Context) Step() *Error {
+	c.steps++
+	if c.steps > c.limits.MaxSteps {
+		return limitError(STEP_LIMIT, "Step limit exceeded: more than %d steps", c.limits.MaxSteps)
+	}
+	if c.done != nil && c.steps%CANCEL_CHECK_INTERVAL == 0 {
+		return c.checkCancelled()
+	}
+	return nil
+}
+
+func (c *Context) checkCancelled() *Error {
+	select {
+	case <-c.done:
+		if c.cause() == context.DeadlineExceeded {
+			return limitError(TIMEOUT, "Timeout: the program ran for too long")
+		}
+		return limitError(CANCELLED, "Cancelled: the program was stopped by its host")
+	default:
+		return nil
+	}
+}
+
+// Enter counts a recipe call, it fails when the call would nest deeper than allowed
+func (c *Context) Enter() *Error {
+	if c.depth >= c.limits.MaxDepth {
+		return c.DepthError()
+	}
+	c.depth++
+	return nil
+}
+
+// Leave ends a recipe call started with Enter
+func (c *Context) Leave() {
+	c.depth--
+}
+
+// MaxDepth is the number of nested recipe calls allowed, for engines keeping their own call stack
+func (c *Context) MaxDepth() int {
+	return c.limits.MaxDepth
+}
+
+func (c *Context) DepthError() *Error {
+	return limitError(DEPTH_LIMIT, "Stack overflow: more than %d nested recipe calls", c.limits.MaxDepth)
+}
+
+// Allocate counts bytes allocated by the run, it fails once they go over its memory limit
+func (c *Context) Allocate(bytes int64) *Error {
+	c.memory += bytes
+	if c.memory > c.limits.MaxMemory {
+		return limitError(MEMORY_LIMIT, "Memory limit exceeded: more than %d bytes allocated", c.limits.MaxMemory)
+	}
+	return nil
+}
+
+// Approximate sizes used to account for allocations
+const (
+	OBJECT_SIZE  = 32
+	ELEMENT_SIZE = 16
+	PAIR_SIZE    = 64
+)
+
+// SizeOf estimates the bytes allocated for obj itself, not counting the objects it holds
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *Boolean, *Null:
+		return 0
+	case *String:
+		return OBJECT_SIZE + int64(len(obj.Value))
+	case *Array:
+		return OBJECT_SIZE + ELEMENT_SIZE*int64(len(obj.Elements))
+	case *Hash:
+		return OBJECT_SIZE + PAIR_SIZE*int64(obj.Len())
+	default:
+		return OBJECT_SIZE
+	}
+}
+
+func limitError(limit Limit, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Limit: limit}
+}
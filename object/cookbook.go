@@ -1,19 +1,30 @@
 package object
 
+import "sort"
+
 type Cookbook struct {
 	page          map[string]Object
 	extended_from *Cookbook
+	context       *Context
 }
 
 func NewCookbook() *Cookbook {
+	return NewCookbookWithContext(DefaultContext())
+}
+
+// NewCookbookWithContext creates a root cookbook whose programs do their I/O through context
+func NewCookbookWithContext(context *Context) *Cookbook {
 	s := make(map[string]Object)
-	return &Cookbook{page: s, extended_from: nil}
+	return &Cookbook{page: s, extended_from: nil, context: context}
 }
 
 func NewExtendedCookbook(extended_from *Cookbook) *Cookbook {
-	book := NewCookbook()
-	book.extended_from = extended_from
-	return book
+	s := make(map[string]Object)
+	return &Cookbook{page: s, extended_from: extended_from, context: extended_from.context}
+}
+
+func (c *Cookbook) Context() *Context {
+	return c.context
 }
 
 func (c *Cookbook) Get(name string) (Object, bool) {
@@ -24,7 +35,34 @@ func (c *Cookbook) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Names returns the names bound in this page in alphabetical order, not those of the cookbooks it extends
+func (c *Cookbook) Names() []string {
+	names := make([]string, 0, len(c.page))
+	for name := range c.page {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Outer returns the cookbook this one extends, nil for a root cookbook
+func (c *Cookbook) Outer() *Cookbook {
+	return c.extended_from
+}
+
 func (c *Cookbook) Set(name string, val Object) Object {
 	c.page[name] = val
 	return val
 }
+
+// Assign updates an existing binding in the page that defines it, it returns false
+// when the name isn't bound in this cookbook or the ones it extends
+func (c *Cookbook) Assign(name string, val Object) (Object, bool) {
+	for book := c; book != nil; book = book.extended_from {
+		if _, ok := book.page[name]; ok {
+			book.page[name] = val
+			return val, true
+		}
+	}
+	return nil, false
+}
@@ -3,8 +3,11 @@ package object
 import (
 	"bytes"
 	"cottagepie/ast"
+	"cottagepie/code"
+	"cottagepie/token"
 	"fmt"
-	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -12,20 +15,29 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	STRING_OBJ       = "STRING"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	SERVES_VALUE_OBJ = "SERVES_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	RECIPE_OBJ       = "RECIPE"
 	BUILT_IN_OBJ     = "BUILT_IN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_RECIPE_OBJ = "COMPILED_RECIPE"
 )
 
 type Object interface {
 	Type() ObjectType
 	Inspect() string
+	// Equal is what == means in programs: numbers compare by value whatever their type,
+	// arrays and hashes by their contents and recipes are equal when they run the same code
+	// on the same bindings. Everything else is only equal to itself
+	Equal(other Object) bool
 }
 
 // Integer
@@ -36,6 +48,50 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) HashKey() HashKey { return HashKey{Type: i.Type(), Value: uint64(i.Value)} }
+func (i *Integer) Equal(other Object) bool {
+	switch other := other.(type) {
+	case *Integer:
+		return i.Value == other.Value
+	case *Float:
+		return float64(i.Value) == other.Value
+	default:
+		return false
+	}
+}
+
+// Float
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	str := strconv.FormatFloat(f.Value, 'g', -1, 64)
+
+	// Keep floats with integral values distinguishable from integers, eg: 3.0
+	if !strings.ContainsAny(str, ".eIN") {
+		str += ".0"
+	}
+	return str
+}
+
+// Integral floats share the key of the equal integer, so {1: "a"}[1.0] finds "a"
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+func (f *Float) Equal(other Object) bool {
+	switch other := other.(type) {
+	case *Float:
+		return f.Value == other.Value
+	case *Integer:
+		return f.Value == float64(other.Value)
+	default:
+		return false
+	}
+}
 
 // String
 type String struct {
@@ -44,11 +100,10 @@ type String struct {
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
-func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
-
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+func (s *String) HashKey() HashKey { return HashKey{Type: s.Type(), Text: s.Value} }
+func (s *String) Equal(other Object) bool {
+	str, ok := other.(*String)
+	return ok && s.Value == str.Value
 }
 
 // Boolean
@@ -69,94 +124,322 @@ func (b *Boolean) HashKey() HashKey {
 
 	return HashKey{Type: b.Type(), Value: value}
 }
+func (b *Boolean) Equal(other Object) bool {
+	boolean, ok := other.(*Boolean)
+	return ok && b.Value == boolean.Value
+}
 
 // Null
 type Null struct{}
 
 func (i *Null) Type() ObjectType { return NULL_OBJ }
 func (i *Null) Inspect() string  { return "null" }
+func (i *Null) Equal(other Object) bool {
+	_, ok := other.(*Null)
+	return ok
+}
 
 // Serves Value
 type ServesValue struct {
 	Value Object
 }
 
-func (sv *ServesValue) Type() ObjectType { return SERVES_VALUE_OBJ }
-func (sv *ServesValue) Inspect() string  { return sv.Value.Inspect() }
+func (sv *ServesValue) Type() ObjectType        { return SERVES_VALUE_OBJ }
+func (sv *ServesValue) Inspect() string         { return sv.Value.Inspect() }
+func (sv *ServesValue) Equal(other Object) bool { return sv == other }
+
+// Break and Continue stop the current loop iteration, like ServesValue they are never seen by users
+type Break struct{}
+
+func (b *Break) Type() ObjectType        { return BREAK_OBJ }
+func (b *Break) Inspect() string         { return "break" }
+func (b *Break) Equal(other Object) bool { return b == other }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType        { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string         { return "continue" }
+func (c *Continue) Equal(other Object) bool { return c == other }
 
 // Error
 type Error struct {
 	Message string
+	Pos     token.Position // where in the source the error was raised, if known
+	Trace   []Frame        // the recipe calls the error went through, innermost first
+	Limit   Limit          // the execution limit that stopped the program, if any
 }
 
-func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR>> " + e.Message }
+// Limit names an execution limit, so hosts can tell why a program was stopped
+type Limit string
+
+const (
+	NO_LIMIT     Limit = ""
+	STEP_LIMIT   Limit = "steps"
+	DEPTH_LIMIT  Limit = "depth"
+	MEMORY_LIMIT Limit = "memory"
+	TIMEOUT      Limit = "timeout"
+	CANCELLED    Limit = "cancelled"
+)
+
+// Frame is a recipe call, CallSite is where the recipe was called from
+type Frame struct {
+	Name     string
+	CallSite token.Position
+}
+
+// Traces longer than this only show their innermost and outermost frames
+const MAX_TRACE_FRAMES = 20
+
+func (e *Error) Type() ObjectType        { return ERROR_OBJ }
+func (e *Error) Equal(other Object) bool { return e == other }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR>> " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR>> " + e.Message
+}
+
+// StackTrace formats the trace with one "at recipe (position)" line per frame,
+// the position being where execution was inside that recipe
+func (e *Error) StackTrace() string {
+	if len(e.Trace) == 0 {
+		return ""
+	}
+
+	var out bytes.Buffer
+	lines := []string{}
+
+	pos := e.Pos
+	for _, frame := range e.Trace {
+		lines = append(lines, fmt.Sprintf("at %s (%s)", frameName(frame.Name), pos))
+		pos = frame.CallSite
+	}
+	lines = append(lines, fmt.Sprintf("at <main> (%s)", pos))
+
+	if len(lines) > MAX_TRACE_FRAMES {
+		half := MAX_TRACE_FRAMES / 2
+		omitted := fmt.Sprintf("... %d more frames", len(lines)-MAX_TRACE_FRAMES)
+		lines = append(append(lines[:half:half], omitted), lines[len(lines)-half:]...)
+	}
+
+	for _, line := range lines {
+		out.WriteString("\t" + line + "\n")
+	}
+
+	return out.String()
+}
+
+func frameName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
 
 // Recipe
 type Recipe struct {
+	Name       string // the name the recipe was baked to, empty for anonymous recipes
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression // default value of each parameter, nil when it has none
+	Rest       *ast.Identifier  // collects the extra arguments, nil when the recipe isn't variadic
 	Body       *ast.BlockStatement
 	Cookbook   *Cookbook
 }
 
 func (r *Recipe) Type() ObjectType { return RECIPE_OBJ }
 func (r *Recipe) Inspect() string {
-	var out bytes.Buffer
+	return inspectRecipe(r.Name, r.Parameters, r.Defaults, r.Rest, r.Body)
+}
+func (r *Recipe) Equal(other Object) bool {
+	recipe, ok := other.(*Recipe)
+	return ok && r.Body == recipe.Body && r.Cookbook == recipe.Cookbook
+}
 
-	params := []string{}
-	for _, p := range r.Parameters {
-		params = append(params, p.String())
-	}
+func inspectRecipe(name string, params []*ast.Identifier, defaults []ast.Expression, rest *ast.Identifier, body *ast.BlockStatement) string {
+	var out bytes.Buffer
 
 	out.WriteString("recipe")
+	if name != "" {
+		out.WriteString(" " + name)
+	}
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(ast.ParameterList(params, defaults, rest))
 	out.WriteString(") {\n")
-	out.WriteString(r.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
 }
 
-// Built In Recipe
-type BuiltInRecipe func(args ...Object) Object
+// Arity returns how many arguments the recipe accepts, max is -1 for variadic recipes
+func (r *Recipe) Arity() (min int, max int) {
+	for i := range r.Parameters {
+		if i < len(r.Defaults) && r.Defaults[i] != nil {
+			break
+		}
+		min += 1
+	}
 
-type BuiltIn struct {
-	Fn BuiltInRecipe
+	if r.Rest != nil {
+		return min, -1
+	}
+	return min, len(r.Parameters)
 }
 
-func (b *BuiltIn) Type() ObjectType { return BUILT_IN_OBJ }
-func (b *BuiltIn) Inspect() string  { return "built-in function" }
+// Compiled Recipe is a recipe lowered to bytecode, the vm wraps it in a Closure to call it
+type CompiledRecipe struct {
+	Instructions  code.Instructions
+	Positions     map[int]token.Position // source position of the instructions that can fail, by offset
+	NumLocals     int
+	NumParameters int
+	NumRequired   int  // parameters without a default value
+	Variadic      bool // the rest parameter takes the local slot right after the parameters
+	LocalNames    []string
+	Literal       *ast.RecipeLiteral // nil for the main program
+}
 
-// Array
-type Array struct {
-	Elements []Object
+func (cr *CompiledRecipe) Type() ObjectType        { return COMPILED_RECIPE_OBJ }
+func (cr *CompiledRecipe) Inspect() string         { return fmt.Sprintf("CompiledRecipe[%p]", cr) }
+func (cr *CompiledRecipe) Equal(other Object) bool { return cr == other }
+
+// Closure is a compiled recipe along with the locals of the call it was created in,
+// to users it is just a recipe
+type Closure struct {
+	Name   string
+	Recipe *CompiledRecipe
+	Env    *Env
 }
 
-func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
-	var out bytes.Buffer
+func (c *Closure) Type() ObjectType { return RECIPE_OBJ }
+func (c *Closure) Inspect() string {
+	lit := c.Recipe.Literal
+	return inspectRecipe(c.Name, lit.Parameters, lit.Defaults, lit.Rest, lit.Body)
+}
+func (c *Closure) Equal(other Object) bool {
+	closure, ok := other.(*Closure)
+	return ok && c.Recipe == closure.Recipe && c.Env == closure.Env
+}
 
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+// Arity returns how many arguments the closure accepts, max is -1 for variadic recipes
+func (c *Closure) Arity() (min int, max int) {
+	if c.Recipe.Variadic {
+		return c.Recipe.NumRequired, -1
 	}
+	return c.Recipe.NumRequired, c.Recipe.NumParameters
+}
 
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
+// Env holds the locals of a compiled recipe call, shared with the closures created during
+// that call so they see later assignments. Parent is the Env the recipe itself was created in
+type Env struct {
+	Slots  []Object
+	Names  []string // the name of each slot, for error messages
+	Parent *Env
+}
 
-	return out.String()
+// Outer returns the Env of the recipe depth levels up from this one
+func (e *Env) Outer(depth int) *Env {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.Parent
+	}
+	return env
 }
 
+// Built In Recipe, ctx is the context of the program calling it
+type BuiltInRecipe func(ctx *Context, args ...Object) Object
+
+type BuiltIn struct {
+	Fn BuiltInRecipe
+}
+
+func (b *BuiltIn) Type() ObjectType        { return BUILT_IN_OBJ }
+func (b *BuiltIn) Inspect() string         { return "built-in function" }
+func (b *BuiltIn) Equal(other Object) bool { return b == other }
+
+// Array
+type Array struct {
+	Elements []Object
+	Frozen   bool // arrays used as hash keys are frozen copies, changing them would lose the pair
+}
+
+func (ao *Array) Type() ObjectType        { return ARRAY_OBJ }
+func (ao *Array) Inspect() string         { return inspect(ao) }
+func (ao *Array) Equal(other Object) bool { return deepEqual(ao, other, nil) }
+
 // Hash
 type Hashable interface {
 	HashKey() HashKey
 }
 
+// HashKey identifies a key exactly, numbers and booleans by Value, strings and arrays by
+// Text, so different keys never collide
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
+	Text  string
+}
+
+// HashKeyOf returns the key of obj in a hash, false when obj can't be used as a key. Arrays
+// are keyed by their contents and can be used when all of their elements can
+func HashKeyOf(obj Object) (HashKey, bool) {
+	if array, ok := obj.(*Array); ok {
+		var text strings.Builder
+		if !writeArrayKey(&text, array, map[*Array]bool{}) {
+			return HashKey{}, false
+		}
+		return HashKey{Type: ARRAY_OBJ, Text: text.String()}, true
+	}
+
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return HashKey{}, false
+	}
+	return hashable.HashKey(), true
+}
+
+// writeArrayKey writes the type, value and length prefixed text of every element key, nested
+// arrays between brackets straight into out so deep nesting stays linear. Arrays containing
+// themselves have no key
+func writeArrayKey(out *strings.Builder, array *Array, visiting map[*Array]bool) bool {
+	if visiting[array] {
+		return false
+	}
+	visiting[array] = true
+	defer delete(visiting, array)
+
+	for _, element := range array.Elements {
+		if nested, ok := element.(*Array); ok {
+			out.WriteByte('[')
+			if !writeArrayKey(out, nested, visiting) {
+				return false
+			}
+			out.WriteByte(']')
+			continue
+		}
+
+		hashable, ok := element.(Hashable)
+		if !ok {
+			return false
+		}
+		key := hashable.HashKey()
+		fmt.Fprintf(out, "%s:%d:%d:%s;", key.Type, key.Value, len(key.Text), key.Text)
+	}
+	return true
+}
+
+// FreezeKey returns what a hash stores as the key for obj: a frozen copy for arrays that
+// aren't frozen yet, obj itself otherwise
+func FreezeKey(obj Object) Object {
+	array, ok := obj.(*Array)
+	if !ok || array.Frozen {
+		return obj
+	}
+
+	elements := make([]Object, len(array.Elements))
+	for i, element := range array.Elements {
+		elements[i] = FreezeKey(element)
+	}
+	return &Array{Elements: elements, Frozen: true}
 }
 
 type HashPair struct {
@@ -164,23 +447,112 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash keeps its pairs in insertion order, so iterating and printing it is deterministic
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs []HashPair
+	index map[HashKey]int // the position of each key in pairs
 }
 
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
+func NewHash() *Hash {
+	return &Hash{index: make(map[HashKey]int)}
+}
 
-	pairs := []string{}
+func (h *Hash) Get(key HashKey) (HashPair, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return HashPair{}, false
+	}
+	return h.pairs[i], true
+}
 
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+// Set replaces the pair of an existing key in place, new keys go after the others
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if i, ok := h.index[key]; ok {
+		h.pairs[i] = pair
+		return
 	}
+	h.index[key] = len(h.pairs)
+	h.pairs = append(h.pairs, pair)
+}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+// Delete removes the pair of key, the pairs after it keep their order
+func (h *Hash) Delete(key HashKey) bool {
+	i, ok := h.index[key]
+	if !ok {
+		return false
+	}
 
-	return out.String()
+	h.pairs = append(h.pairs[:i:i], h.pairs[i+1:]...)
+	delete(h.index, key)
+	for k, position := range h.index {
+		if position > i {
+			h.index[k] = position - 1
+		}
+	}
+	return true
+}
+
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs in insertion order, the slice belongs to the hash and must not be modified
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
+func (h *Hash) Type() ObjectType        { return HASH_OBJ }
+func (h *Hash) Inspect() string         { return inspect(h) }
+func (h *Hash) Equal(other Object) bool { return deepEqual(h, other, nil) }
+
+// deepEqual compares arrays and hashes element by element. Collections can contain themselves,
+// seen holds the pairs being compared further up so a cycle counts as equal instead of looping
+func deepEqual(a, b Object, seen map[[2]Object]bool) bool {
+	switch a := a.(type) {
+	case *Array:
+		other, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(other.Elements) {
+			return false
+		}
+		if a == other || seen[[2]Object{a, other}] {
+			return true
+		}
+
+		if seen == nil {
+			seen = make(map[[2]Object]bool)
+		}
+		seen[[2]Object{a, other}] = true
+
+		for i, element := range a.Elements {
+			if !deepEqual(element, other.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+
+	case *Hash:
+		other, ok := b.(*Hash)
+		if !ok || a.Len() != other.Len() {
+			return false
+		}
+		if a == other || seen[[2]Object{a, other}] {
+			return true
+		}
+
+		if seen == nil {
+			seen = make(map[[2]Object]bool)
+		}
+		seen[[2]Object{a, other}] = true
+
+		for key, i := range a.index {
+			otherPair, ok := other.Get(key)
+			if !ok || !deepEqual(a.pairs[i].Value, otherPair.Value, seen) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return a.Equal(b)
+	}
 }
@@ -1,6 +1,13 @@
 package object
 
-import "testing"
+import (
+	"cottagepie/ast"
+	"cottagepie/lexer"
+	"cottagepie/parser"
+	"cottagepie/token"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +65,302 @@ func TestBooleanHashKey(t *testing.T) {
 		t.Errorf("Booleans with different content have same hash keys")
 	}
 }
+
+func TestArrayHashKey(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	str := func(value string) *String { return &String{Value: value} }
+
+	same1, ok1 := HashKeyOf(array(&Integer{Value: 1}, array(str("a"))))
+	same2, ok2 := HashKeyOf(array(&Float{Value: 1}, array(str("a"))))
+	if !ok1 || !ok2 || same1 != same2 {
+		t.Errorf("Arrays with equal content have different hash keys")
+	}
+
+	different := [][2]*Array{
+		{array(str("a"), str("bc")), array(str("ab"), str("c"))},
+		{array(str("1")), array(&Integer{Value: 1})},
+		{array(array(&Integer{Value: 1})), array(&Integer{Value: 1})},
+		{array(), array(array())},
+		{array(array(), array()), array(array(array()))},
+		{array(array(str("a")), str("b")), array(array(str("a"), str("b")))},
+		{array(str("]["), array()), array(array(), str("]["))},
+	}
+	for _, pair := range different {
+		left, _ := HashKeyOf(pair[0])
+		right, _ := HashKeyOf(pair[1])
+		if left == right {
+			t.Errorf("Arrays %s and %s have the same hash key", pair[0].Inspect(), pair[1].Inspect())
+		}
+	}
+
+	if _, ok := HashKeyOf(array(&Null{})); ok {
+		t.Errorf("Arrays of unhashable elements should have no hash key")
+	}
+
+	// Nested arrays are written straight into the key, deep ones take linear time
+	deep, deeper := array(), array()
+	for i := 0; i < 20000; i++ {
+		deep, deeper = array(deep), array(deeper)
+	}
+	deepKey, ok1 := HashKeyOf(deep)
+	deeperKey, ok2 := HashKeyOf(array(deeper))
+	if !ok1 || !ok2 || deepKey == deeperKey || len(deepKey.Text) != 2*20000 {
+		t.Errorf("Deeply nested arrays have wrong hash keys")
+	}
+
+	frozen := FreezeKey(array(array(&Integer{Value: 1}))).(*Array)
+	if !frozen.Frozen || !frozen.Elements[0].(*Array).Frozen {
+		t.Errorf("FreezeKey should freeze nested arrays too")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cyclic := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	cyclic.Elements[1] = cyclic
+	cyclicCopy := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	cyclicCopy.Elements[1] = cyclicCopy
+
+	one := &String{Value: "one"}
+	hash := func(value Object) *Hash {
+		hash := NewHash()
+		hash.Set(one.HashKey(), HashPair{Key: one, Value: value})
+		return hash
+	}
+
+	body := &ast.BlockStatement{}
+	book := NewCookbook()
+
+	tests := []struct {
+		left     Object
+		right    Object
+		expected bool
+	}{
+		{&Integer{Value: 2}, &Integer{Value: 2}, true},
+		{&Integer{Value: 2}, &Float{Value: 2.0}, true},
+		{&Float{Value: 2.5}, &Integer{Value: 2}, false},
+		{&String{Value: "2"}, &Integer{Value: 2}, false},
+		{&Null{}, &Null{}, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}},
+			&Array{Elements: []Object{&Float{Value: 1}, &String{Value: "a"}}}, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}}}, &Array{Elements: []Object{}}, false},
+		{hash(&Array{}), hash(&Array{}), true},
+		{hash(&Integer{Value: 1}), hash(&Integer{Value: 2}), false},
+		{hash(&Integer{Value: 1}), NewHash(), false},
+		{cyclic, cyclicCopy, true},
+		{&Recipe{Body: body, Cookbook: book}, &Recipe{Body: body, Cookbook: book}, true},
+		{&Recipe{Body: body, Cookbook: book}, &Recipe{Body: body, Cookbook: NewCookbook()}, false},
+		{&BuiltIn{}, &BuiltIn{}, false},
+	}
+
+	for _, tt := range tests {
+		if tt.left.Equal(tt.right) != tt.expected || tt.right.Equal(tt.left) != tt.expected {
+			t.Errorf("Wrong equality of %s and %s. expected=%t", tt.left.Type(), tt.right.Type(), tt.expected)
+		}
+	}
+}
+
+func TestStackTraceTruncation(t *testing.T) {
+	err := &Error{Message: "boom", Pos: token.Position{Line: 1, Column: 1}}
+	for i := 0; i < 50; i++ {
+		err.Trace = append(err.Trace, Frame{Name: "loop", CallSite: token.Position{Line: 2, Column: 1}})
+	}
+
+	lines := strings.Split(strings.TrimSuffix(err.StackTrace(), "\n"), "\n")
+	if len(lines) != MAX_TRACE_FRAMES+1 {
+		t.Fatalf("Wrong number of trace lines. expected=%d, got=%d", MAX_TRACE_FRAMES+1, len(lines))
+	}
+
+	if lines[MAX_TRACE_FRAMES/2] != "\t... 31 more frames" {
+		t.Errorf("Wrong omitted frames line, got=%q", lines[MAX_TRACE_FRAMES/2])
+	}
+
+	if lines[len(lines)-1] != "\tat <main> (2:1)" {
+		t.Errorf("Wrong outermost frame, got=%q", lines[len(lines)-1])
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{3, "3.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		float := &Float{Value: tt.value}
+		if float.Inspect() != tt.expected {
+			t.Errorf("Wrong Inspect() for %g. expected=%q, got=%q", tt.value, tt.expected, float.Inspect())
+		}
+	}
+}
+
+func TestCookbookAssign(t *testing.T) {
+	outer := NewCookbook()
+	outer.Set("count", &Integer{Value: 1})
+	inner := NewExtendedCookbook(outer)
+
+	if _, ok := inner.Assign("count", &Integer{Value: 2}); !ok {
+		t.Fatalf("Assign didn't find a name bound in the extended cookbook")
+	}
+
+	if _, ok := inner.page["count"]; ok {
+		t.Errorf("Assign created a new binding in the inner page")
+	}
+
+	value, _ := outer.Get("count")
+	if value.(*Integer).Value != 2 {
+		t.Errorf("Assign didn't update the defining page, got=%s", value.Inspect())
+	}
+
+	if _, ok := inner.Assign("missing", &Integer{Value: 1}); ok {
+		t.Errorf("Assign succeeded for an undefined name")
+	}
+}
+
+func TestCookbookNames(t *testing.T) {
+	outer := NewCookbook()
+	outer.Set("pie", &Integer{Value: 1})
+	outer.Set("cake", &Integer{Value: 2})
+	inner := NewExtendedCookbook(outer)
+	inner.Set("bun", &Integer{Value: 3})
+
+	if names := strings.Join(outer.Names(), " "); names != "cake pie" {
+		t.Errorf("Wrong names. expected=%q, got=%q", "cake pie", names)
+	}
+	if names := strings.Join(inner.Names(), " "); names != "bun" {
+		t.Errorf("Names listed the names of the outer cookbook. got=%q", names)
+	}
+	if inner.Outer() != outer || outer.Outer() != nil {
+		t.Errorf("Wrong outer cookbooks")
+	}
+}
+
+func TestHashDelete(t *testing.T) {
+	hash := NewHash()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		key := &String{Value: name}
+		hash.Set(key.HashKey(), HashPair{Key: key, Value: &Boolean{Value: true}})
+	}
+
+	pairs := hash.Pairs()
+	if !hash.Delete((&String{Value: "b"}).HashKey()) {
+		t.Fatalf("Delete didn't find an existing key")
+	}
+	if hash.Delete((&String{Value: "b"}).HashKey()) {
+		t.Errorf("Delete found a key twice")
+	}
+	if pairs[1].Key.Inspect() != "b" {
+		t.Errorf("Delete modified a slice returned by Pairs")
+	}
+
+	if hash.Inspect() != "{a: true, c: true, d: true}" {
+		t.Errorf("Wrong pairs after Delete, got=%s", hash.Inspect())
+	}
+
+	pair, ok := hash.Get((&String{Value: "d"}).HashKey())
+	if !ok || pair.Key.Inspect() != "d" {
+		t.Errorf("Delete broke the index of the following keys, got=%v", pair)
+	}
+}
+
+func TestPretty(t *testing.T) {
+	hashOf := func(pairs ...Object) *Hash {
+		hash := NewHash()
+		for i := 0; i < len(pairs); i += 2 {
+			key, _ := HashKeyOf(pairs[i])
+			hash.Set(key, HashPair{Key: pairs[i], Value: pairs[i+1]})
+		}
+		return hash
+	}
+	str := func(value string) *String { return &String{Value: value} }
+	integers := func(count int) *Array {
+		array := &Array{}
+		for i := 0; i < count; i++ {
+			array.Elements = append(array.Elements, &Integer{Value: int64(i)})
+		}
+		return array
+	}
+
+	cyclic := &Array{Elements: []Object{&Integer{Value: 1}}}
+	cyclic.Elements = append(cyclic.Elements, cyclic)
+
+	config := hashOf(
+		str("name"), str("cottage"),
+		str("oven"), hashOf(str("temperature"), &Integer{Value: 180}, str("fan"), &Boolean{Value: true}),
+		str("steps"), &Array{Elements: []Object{str("mix the flour with the butter"), str("bake for twenty minutes"), &Null{}}},
+	)
+
+	body := &ast.BlockStatement{Statements: []ast.Statement{
+		&ast.ExpressionStatement{Expression: &ast.Identifier{Value: "a"}},
+		&ast.ExpressionStatement{Expression: &ast.Identifier{Value: "b"}},
+	}}
+	recipe := &Recipe{Name: "pair", Parameters: []*ast.Identifier{{Value: "a"}, {Value: "b"}}, Body: body}
+
+	program := parser.New(lexer.New(`rc(a) {
+  if (a) { 1 } else {
+    bake z to 2; z
+  };
+  while (a < 3) { a += 1 };
+  for (x in [1]) {
+    plates(x) } }`)).ParseProgram()
+	lit := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.RecipeLiteral)
+	nested := &Recipe{Name: "count", Parameters: lit.Parameters, Body: lit.Body}
+
+	tests := []struct {
+		value    Object
+		opts     PrettyOptions
+		expected string
+	}{
+		{str("pie"), DefaultPrettyOptions(), "pie"},
+		{&Array{Elements: []Object{str("1"), &Integer{Value: 1}}}, DefaultPrettyOptions(), `["1", 1]`},
+		{config, DefaultPrettyOptions(), `{
+  "name": "cottage",
+  "oven": {"temperature": 180, "fan": true},
+  "steps": ["mix the flour with the butter", "bake for twenty minutes", null]
+}`},
+		{config, PrettyOptions{Width: 44}, `{
+  "name": "cottage",
+  "oven": {"temperature": 180, "fan": true},
+  "steps": [
+    "mix the flour with the butter",
+    "bake for twenty minutes",
+    null
+  ]
+}`},
+		{config, PrettyOptions{}, `{"name": "cottage", "oven": {"temperature": 180, "fan": true}, "steps": ["mix the flour with the butter", "bake for twenty minutes", null]}`},
+		{integers(5), PrettyOptions{MaxElements: 3}, "[0, 1, 2, … 2 more]"},
+		{integers(5), PrettyOptions{Width: 5, MaxElements: 2}, "[\n  0,\n  1,\n  … 3 more\n]"},
+		{str("shortcrust"), PrettyOptions{MaxString: 5}, "short … 5 more"},
+		{cyclic, DefaultPrettyOptions(), "[1, [...]]"},
+		{cyclic, PrettyOptions{Width: 1}, "[\n  1,\n  [...]\n]"},
+		{recipe, DefaultPrettyOptions(), "recipe pair(a, b) {\n  a;\n  b\n}"},
+		{nested, DefaultPrettyOptions(), `recipe count(a) {
+  if (a) { 1 } else {
+    bake z to 2;
+    z
+  }
+  while (a < 3) { a += 1 }
+  for (x in [1]) {
+    plates(x)
+  }
+}`},
+		{&Array{Elements: []Object{recipe}}, DefaultPrettyOptions(), "[recipe pair(a, b) { ... }]"},
+		{&Array{Elements: []Object{&Integer{Value: 1}, str("a"), &Null{}}}, PrettyOptions{Color: true},
+			"[" + COLOR_NUMBER + "1" + COLOR_RESET + ", " + COLOR_STRING + `"a"` + COLOR_RESET + ", " + COLOR_NULL + "null" + COLOR_RESET + "]"},
+	}
+
+	for _, tt := range tests {
+		actual := Pretty(tt.value, tt.opts)
+		if actual != tt.expected {
+			t.Errorf("Wrong Pretty() for %+v. expected=\n%s\ngot=\n%s", tt.opts, tt.expected, actual)
+		}
+	}
+
+	if cyclic.Inspect() != "[1, [...]]" {
+		t.Errorf("Wrong Inspect() for a cyclic array, got=%s", cyclic.Inspect())
+	}
+}
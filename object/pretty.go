@@ -0,0 +1,332 @@
+package object
+
+import (
+	"cottagepie/ast"
+	"cottagepie/formatter"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pretty printing, for people reading values in the REPL. Collections that don't fit in the
+// width are split with one element per line, eg:
+//
+//	{
+//	  "name": "Niko",
+//	  "pantry": ["eggs", "flour"]
+//	}
+const (
+	PRETTY_WIDTH        = 80   // columns a value can take before its collections are split over several lines
+	PRETTY_INDENT       = "  " // what nested lines are indented with
+	MAX_PRETTY_ELEMENTS = 100  // elements shown per array or hash, the others are only counted
+	MAX_PRETTY_STRING   = 1000 // characters shown per string
+)
+
+// ANSI codes values are colored with, by type
+const (
+	COLOR_RESET   = "\x1b[0m"
+	COLOR_NUMBER  = "\x1b[33m"
+	COLOR_STRING  = "\x1b[32m"
+	COLOR_BOOLEAN = "\x1b[35m"
+	COLOR_NULL    = "\x1b[90m" // also for cycles and what was left out
+	COLOR_RECIPE  = "\x1b[36m"
+	COLOR_ERROR   = "\x1b[31m"
+)
+
+type PrettyOptions struct {
+	Width       int  // columns available, 0 keeps every collection on one line
+	MaxElements int  // elements shown per array or hash, 0 shows them all
+	MaxString   int  // characters shown per string, 0 shows them all
+	Color       bool // color values by type with ANSI codes, for terminals
+}
+
+func DefaultPrettyOptions() PrettyOptions {
+	return PrettyOptions{Width: PRETTY_WIDTH, MaxElements: MAX_PRETTY_ELEMENTS, MaxString: MAX_PRETTY_STRING}
+}
+
+// Pretty formats obj to be read by people: strings in collections are quoted, recipes get
+// their body indented, what is left out is counted, eg: [1, 2, … 98 more], and a
+// collection inside itself is shown as [...] or {...}
+func Pretty(obj Object, opts PrettyOptions) string {
+	p := &printer{opts: opts, readable: true, visiting: map[Object]bool{}}
+
+	var out strings.Builder
+	p.pretty(&out, obj, "", 0, false)
+	return out.String()
+}
+
+// inspect writes collections on one line with the Inspect of their elements, like they
+// have always been shown to programs
+func inspect(obj Object) string {
+	p := &printer{visiting: map[Object]bool{}}
+
+	l := &line{limit: -1}
+	p.flat(l, obj, false)
+	return l.text.String()
+}
+
+type printer struct {
+	opts     PrettyOptions
+	readable bool            // quote strings and shorten recipes in collections, Inspect leaves them as they are
+	visiting map[Object]bool // the collections being printed, meeting one again is a cycle
+}
+
+// line is a value written on one line, its width counts the columns taken without the colors
+type line struct {
+	text  strings.Builder
+	width int
+	limit int // the width past which the line is too long and stops growing, -1 for none
+}
+
+func (l *line) write(s string) {
+	l.text.WriteString(s)
+	l.width += utf8.RuneCountInString(s)
+}
+
+func (l *line) full() bool {
+	return l.limit >= 0 && l.width > l.limit
+}
+
+func (p *printer) colored(l *line, s string, color string) {
+	if !p.opts.Color || color == "" {
+		l.write(s)
+		return
+	}
+	l.text.WriteString(color)
+	l.write(s)
+	l.text.WriteString(COLOR_RESET)
+}
+
+// pretty writes obj at the given indentation, column being where it starts on its line. It is
+// kept on one line when it fits, otherwise its collections get one element per line
+func (p *printer) pretty(out *strings.Builder, obj Object, indent string, column int, nested bool) {
+	if !nested {
+		if name, params, body, ok := recipeParts(obj); ok {
+			p.recipe(out, name, params, body, indent)
+			return
+		}
+	}
+
+	l := &line{limit: -1}
+	if p.opts.Width > 0 {
+		// Leave room for the comma following elements
+		l.limit = p.opts.Width - column - 1
+		if l.limit < 0 {
+			l.limit = 0
+		}
+	}
+	p.flat(l, obj, nested)
+	if !l.full() || p.visiting[obj] {
+		out.WriteString(l.text.String())
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		p.visiting[obj] = true
+		defer delete(p.visiting, obj)
+
+		shown, more := p.shown(len(obj.Elements))
+		inner := indent + PRETTY_INDENT
+		out.WriteString("[\n")
+		for i, element := range obj.Elements[:shown] {
+			out.WriteString(inner)
+			p.pretty(out, element, inner, len(inner), true)
+			p.separate(out, i, shown, more)
+		}
+		p.more(out, inner, more)
+		out.WriteString(indent + "]")
+
+	case *Hash:
+		p.visiting[obj] = true
+		defer delete(p.visiting, obj)
+
+		shown, more := p.shown(obj.Len())
+		inner := indent + PRETTY_INDENT
+		out.WriteString("{\n")
+		for i, pair := range obj.Pairs()[:shown] {
+			key := &line{limit: -1}
+			p.flat(key, pair.Key, true)
+			key.write(": ")
+
+			out.WriteString(inner + key.text.String())
+			p.pretty(out, pair.Value, inner, len(inner)+key.width, true)
+			p.separate(out, i, shown, more)
+		}
+		p.more(out, inner, more)
+		out.WriteString(indent + "}")
+
+	default:
+		out.WriteString(l.text.String())
+	}
+}
+
+func (p *printer) separate(out *strings.Builder, i int, shown int, more int) {
+	if i < shown-1 || more > 0 {
+		out.WriteString(",")
+	}
+	out.WriteString("\n")
+}
+
+func (p *printer) more(out *strings.Builder, indent string, more int) {
+	if more > 0 {
+		l := &line{limit: -1}
+		p.colored(l, fmt.Sprintf("… %d more", more), COLOR_NULL)
+		out.WriteString(indent + l.text.String() + "\n")
+	}
+}
+
+// recipe writes the body formatted like cottagepie fmt would, indented under the parameters
+func (p *printer) recipe(out *strings.Builder, name string, params string, body *ast.BlockStatement, indent string) {
+	header := &line{limit: -1}
+	p.colored(header, recipeHeader(name, params), COLOR_RECIPE)
+	out.WriteString(header.text.String())
+
+	if len(body.Statements) == 0 {
+		out.WriteString(" {}")
+		return
+	}
+
+	out.WriteString(" {\n")
+	out.WriteString(formatter.Statements(body.Statements, indent))
+	out.WriteString(indent + "}")
+}
+
+// flat writes obj on one line, it gives up on collections once the line is full
+func (p *printer) flat(l *line, obj Object, nested bool) {
+	if l.full() {
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		if p.visiting[obj] {
+			p.colored(l, "[...]", COLOR_NULL)
+			return
+		}
+		p.visiting[obj] = true
+		defer delete(p.visiting, obj)
+
+		shown, more := p.shown(len(obj.Elements))
+		l.write("[")
+		for i, element := range obj.Elements[:shown] {
+			if i > 0 {
+				l.write(", ")
+			}
+			p.flat(l, element, true)
+			if l.full() {
+				return
+			}
+		}
+		p.flatMore(l, shown, more)
+		l.write("]")
+
+	case *Hash:
+		if p.visiting[obj] {
+			p.colored(l, "{...}", COLOR_NULL)
+			return
+		}
+		p.visiting[obj] = true
+		defer delete(p.visiting, obj)
+
+		shown, more := p.shown(obj.Len())
+		l.write("{")
+		for i, pair := range obj.Pairs()[:shown] {
+			if i > 0 {
+				l.write(", ")
+			}
+			p.flat(l, pair.Key, true)
+			l.write(": ")
+			p.flat(l, pair.Value, true)
+			if l.full() {
+				return
+			}
+		}
+		p.flatMore(l, shown, more)
+		l.write("}")
+
+	default:
+		p.scalar(l, obj, nested)
+	}
+}
+
+func (p *printer) flatMore(l *line, shown int, more int) {
+	if more == 0 {
+		return
+	}
+	if shown > 0 {
+		l.write(", ")
+	}
+	p.colored(l, fmt.Sprintf("… %d more", more), COLOR_NULL)
+}
+
+func (p *printer) scalar(l *line, obj Object, nested bool) {
+	if !p.readable {
+		l.write(obj.Inspect())
+		return
+	}
+
+	switch obj := obj.(type) {
+	case *String:
+		text, more := obj.Value, 0
+		if count := utf8.RuneCountInString(text); p.opts.MaxString > 0 && count > p.opts.MaxString {
+			text, more = string([]rune(text)[:p.opts.MaxString]), count-p.opts.MaxString
+		}
+		if nested {
+			text = strconv.Quote(text)
+		}
+
+		p.colored(l, text, COLOR_STRING)
+		if more > 0 {
+			p.colored(l, fmt.Sprintf(" … %d more", more), COLOR_NULL)
+		}
+
+	case *Integer, *Float:
+		p.colored(l, obj.Inspect(), COLOR_NUMBER)
+	case *Boolean:
+		p.colored(l, obj.Inspect(), COLOR_BOOLEAN)
+	case *Null:
+		p.colored(l, obj.Inspect(), COLOR_NULL)
+	case *Error:
+		p.colored(l, obj.Inspect(), COLOR_ERROR)
+	case *BuiltIn:
+		p.colored(l, obj.Inspect(), COLOR_RECIPE)
+
+	default:
+		// Recipes in collections only show their parameters, the body would take many lines
+		if name, params, _, ok := recipeParts(obj); ok {
+			p.colored(l, recipeHeader(name, params)+" { ... }", COLOR_RECIPE)
+			return
+		}
+		l.write(obj.Inspect())
+	}
+}
+
+// shown is how many of count elements are shown and how many are left out
+func (p *printer) shown(count int) (shown int, more int) {
+	if p.opts.MaxElements > 0 && count > p.opts.MaxElements {
+		return p.opts.MaxElements, count - p.opts.MaxElements
+	}
+	return count, 0
+}
+
+// recipeParts returns what recipes of both engines are shown with, false for other objects
+func recipeParts(obj Object) (name string, params string, body *ast.BlockStatement, ok bool) {
+	switch obj := obj.(type) {
+	case *Recipe:
+		return obj.Name, ast.ParameterList(obj.Parameters, obj.Defaults, obj.Rest), obj.Body, true
+	case *Closure:
+		lit := obj.Recipe.Literal
+		return obj.Name, ast.ParameterList(lit.Parameters, lit.Defaults, lit.Rest), lit.Body, true
+	default:
+		return "", "", nil, false
+	}
+}
+
+func recipeHeader(name string, params string) string {
+	if name != "" {
+		return "recipe " + name + "(" + params + ")"
+	}
+	return "recipe(" + params + ")"
+}
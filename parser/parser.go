@@ -2,15 +2,18 @@ package parser
 
 import (
 	"cottagepie/ast"
+	"cottagepie/diagnostic"
 	"cottagepie/lexer"
 	"cottagepie/token"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGNMENT   // x to 5 or x += 5
 	EQUALS       // ==
 	LESS_GREATER // > or <
 	SUM          // +
@@ -21,16 +24,31 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESS_GREATER,
-	token.GT:       LESS_GREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGNMENT,
+	token.PLUS_ASSIGN:     ASSIGNMENT,
+	token.MINUS_ASSIGN:    ASSIGNMENT,
+	token.ASTERISK_ASSIGN: ASSIGNMENT,
+	token.SLASH_ASSIGN:    ASSIGNMENT,
+	token.PERCENT_ASSIGN:  ASSIGNMENT,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESS_GREATER,
+	token.GT:              LESS_GREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+}
+
+// Precedence returns how tightly an infix operator binds, LOWEST for other tokens
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+	return LOWEST
 }
 
 type (
@@ -40,7 +58,13 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []diagnostic.Diagnostic
+
+	// panicking is set once a statement has an error, further errors are
+	// cascades of the first one and are dropped until the parser resynchronizes
+	panicking bool
+	depth     int // number of braces opened up to curToken
+	loopDepth int // number of loops around curToken inside the current recipe
 
 	curToken  token.Token
 	peekToken token.Token
@@ -50,11 +74,12 @@ type Parser struct {
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []diagnostic.Diagnostic{}}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -65,17 +90,25 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PERCENT_ASSIGN, p.parseAssignExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 
@@ -89,6 +122,13 @@ func New(l *lexer.Lexer) *Parser {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth += 1
+	case token.RBRACE:
+		p.depth -= 1
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -96,13 +136,21 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for !p.curTokenIs(token.EOF) {
+		depth := p.depth
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			p.synchronize(depth)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
 	}
 
+	// Lexer errors come first as they are usually the cause of the parser errors that follow
+	if lexerErrors := p.l.Errors(); len(lexerErrors) > 0 {
+		p.errors = append(append([]diagnostic.Diagnostic{}, lexerErrors...), p.errors...)
+	}
+
 	return program
 }
 
@@ -144,13 +192,58 @@ func (p *Parser) curPrecedence() int {
 	return LOWEST
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []diagnostic.Diagnostic {
 	return p.errors
 }
 
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("Expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	err := diagnostic.New(diagnostic.UNEXPECTED_TOKEN, p.peekToken, msg)
+
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		err.Hint = fmt.Sprintf("Check for a missing closing %s", t)
+	case token.ASSIGN:
+		err.Hint = "Bindings are written as: bake name to value;"
+	}
+
+	p.addError(err)
+}
+
+// addError records a diagnostic unless the parser is already recovering from an earlier one
+func (p *Parser) addError(err diagnostic.Diagnostic) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+
+	for _, existing := range p.errors {
+		if existing.Start == err.Start && existing.Message == err.Message {
+			return
+		}
+	}
+	p.errors = append(p.errors, err)
+}
+
+// synchronize skips the rest of a broken statement which started at the given
+// brace depth: up to its ';' or right before the '}' closing the enclosing block
+func (p *Parser) synchronize(depth int) {
+	for !p.curTokenIs(token.EOF) {
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) {
+				break
+			}
+
+			if p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.BAKE) ||
+				p.peekTokenIs(token.SERVES) || p.peekTokenIs(token.EOF) {
+				break
+			}
+		}
+
+		p.nextToken()
+	}
+
+	p.panicking = false
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -163,7 +256,13 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("No prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	err := diagnostic.New(diagnostic.MISSING_EXPRESSION, p.curToken, msg)
+
+	if t == token.EOF {
+		err.Hint = "The input ended before the expression was complete"
+	}
+
+	p.addError(err)
 }
 
 // Parse Statements
@@ -174,6 +273,17 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseBakeStatement()
 	case token.SERVES:
 		return p.parseServesStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
+	case token.RECIPE:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseRecipeDeclaration()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -196,13 +306,138 @@ func (p *Parser) parseBakeStatement() *ast.BakeStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
 	return stmt
 }
 
+// parseRecipeDeclaration parses `recipe name(params) { body }`
+func (p *Parser) parseRecipeDeclaration() *ast.RecipeDeclaration {
+	stmt := &ast.RecipeDeclaration{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	stmt.Recipe = &ast.RecipeLiteral{Token: stmt.Token}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.parseRecipeParameters(stmt.Recipe) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Recipe.Body = p.parseRecipeBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+// parseForStatement parses `for (x in collection) { ... }` and `for (key, value in collection) { ... }`
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Key = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth += 1
+	body := p.parseBlockStatement()
+	p.loopDepth -= 1
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return body
+}
+
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+
+	if p.loopDepth == 0 {
+		msg := fmt.Sprintf("%s outside of a loop", tok.Literal)
+		p.addError(diagnostic.New(diagnostic.OUTSIDE_LOOP, tok, msg))
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 func (p *Parser) parseServesStatement() *ast.ServesStatement {
 	stmt := &ast.ServesStatement{Token: p.curToken}
 
@@ -210,7 +445,7 @@ func (p *Parser) parseServesStatement() *ast.ServesStatement {
 
 	stmt.ServesValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -235,8 +470,11 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		depth := p.depth
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			p.synchronize(depth)
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
@@ -252,7 +490,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(diagnostic.New(diagnostic.INVALID_INTEGER, p.curToken, msg))
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.addError(diagnostic.New(diagnostic.INVALID_FLOAT, p.curToken, msg))
 		return nil
 	}
 
@@ -267,42 +519,88 @@ func (p *Parser) parseRecipeLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseRecipeParameters()
+	if !p.parseRecipeParameters(lit) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseRecipeBody()
 
 	return lit
 }
 
-func (p *Parser) parseRecipeParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+// parseRecipeBody parses a recipe body, loops around the recipe can't be broken out of from inside it
+func (p *Parser) parseRecipeBody() *ast.BlockStatement {
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	body := p.parseBlockStatement()
+	p.loopDepth = loopDepth
+
+	return body
+}
+
+// parseRecipeParameters parses `a, b = 2, ...rest)`, parameters with a default
+// value must come after the required ones and the rest parameter comes last
+func (p *Parser) parseRecipeParameters(lit *ast.RecipeLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
+	lit.Defaults = []ast.Expression{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return true
 	}
 
-	p.nextToken()
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+		var defaultValue ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			defaultValue = p.parseExpression(LOWEST)
+		} else if count := len(lit.Defaults); count > 0 && lit.Defaults[count-1] != nil {
+			msg := fmt.Sprintf("Parameter %s needs a default value as it follows a parameter with one", ident.Value)
+			p.addError(diagnostic.New(diagnostic.INVALID_PARAMETER, ident.Token, msg))
+			return false
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+		lit.Parameters = append(lit.Parameters, ident)
+		lit.Defaults = append(lit.Defaults, defaultValue)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	if lit.Rest != nil && !p.peekTokenIs(token.RPAREN) {
+		msg := fmt.Sprintf("The rest parameter ...%s must be the last parameter", lit.Rest.Value)
+		p.addError(diagnostic.New(diagnostic.INVALID_PARAMETER, p.peekToken, msg))
+		return false
 	}
 
-	return identifiers
+	return p.expectPeek(token.RPAREN)
+}
+
+func (p *Parser) parseIllegal() ast.Expression {
+	msg := fmt.Sprintf("Illegal character %q", p.curToken.Literal)
+	p.addError(diagnostic.New(diagnostic.ILLEGAL_CHARACTER, p.curToken, msg))
+	return nil
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
@@ -323,7 +621,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	hash.Pairs = []ast.HashLiteralPair{}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -336,7 +634,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashLiteralPair{Key: key, Value: value})
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -412,6 +710,33 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseAssignExpression parses `target to value` and compound forms like `target += value`,
+// assignments are right associative so `a to b to 1` assigns 1 to both
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{Token: p.curToken, Target: target}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil
+	default:
+		msg := fmt.Sprintf("Cannot assign to %s", target.String())
+		err := diagnostic.New(diagnostic.INVALID_ASSIGNMENT, p.curToken, msg)
+		err.Hint = "Only names and index expressions like list[0] can be assigned to"
+		p.addError(err)
+		return nil
+	}
+
+	if !p.curTokenIs(token.ASSIGN) {
+		expression.Operator = strings.TrimSuffix(p.curToken.Literal, "=")
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGNMENT - 1)
+
+	return expression
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
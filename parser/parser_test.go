@@ -2,6 +2,7 @@ package parser
 
 import (
 	"cottagepie/ast"
+	"cottagepie/diagnostic"
 	"cottagepie/lexer"
 	"fmt"
 	"testing"
@@ -130,6 +131,41 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{"2e3;", 2000},
+		{"1.25e-2;", 0.0125},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+
+	l := lexer.New("1e400;")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 || p.Errors()[0].Code != diagnostic.INVALID_FLOAT {
+		t.Errorf("Expected an invalid float error, got=%v", p.Errors())
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"Cristiano Ronaldo";`
 	expected := "Cristiano Ronaldo"
@@ -306,6 +342,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b / c",
 			"(a + (b / c))",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
 		{
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
@@ -386,6 +426,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"x to 1 + 2",
+			"(x to (1 + 2))",
+		},
+		{
+			"a = b = c == d",
+			"(a = (b = (c == d)))",
+		},
+		{
+			"list[i + 1] += 2 * 3",
+			"((list[(i + 1)]) += (2 * 3))",
+		},
+		{
+			`{"c": 1, "a": 2 + 3, "b": 4}`,
+			"{c:1, a:(2 + 3), b:4}",
+		},
 	}
 
 	for _, tt := range tests {
@@ -612,6 +668,201 @@ func TestRecipeParameterParsing(t *testing.T) {
 	}
 }
 
+func TestRecipeDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedString string
+		expectedRest   string
+	}{
+		{"recipe(a, b = 2) {};", "recipe(a, b = 2)", ""},
+		{"rc(a, b to 1 + 1, ...rest) {};", "rc(a, b = (1 + 1), ...rest)", "rest"},
+		{"rc(...items) {};", "rc(...items)", "items"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		recipe := stmt.Expression.(*ast.RecipeLiteral)
+
+		if recipe.String() != tt.expectedString {
+			t.Errorf("recipe.String() wrong. expected=%q, got=%q", tt.expectedString, recipe.String())
+		}
+
+		if len(recipe.Defaults) != len(recipe.Parameters) {
+			t.Errorf("Defaults and Parameters have different lengths. got=%d and %d",
+				len(recipe.Defaults), len(recipe.Parameters))
+		}
+
+		if tt.expectedRest == "" {
+			if recipe.Rest != nil {
+				t.Errorf("recipe.Rest is not nil. got=%q", recipe.Rest)
+			}
+		} else if recipe.Rest == nil || recipe.Rest.Value != tt.expectedRest {
+			t.Errorf("recipe.Rest wrong. expected=%q, got=%+v", tt.expectedRest, recipe.Rest)
+		}
+	}
+}
+
+func TestInvalidRecipeParameters(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"rc(a = 1, b) {};", "1:11: Parameter b needs a default value as it follows a parameter with one"},
+		{"rc(...rest, a) {};", "1:11: The rest parameter ...rest must be the last parameter"},
+		{"rc(1) {};", "1:4: Expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("Expected parser errors for %q, got none", tt.input)
+		}
+
+		if errors[0].String() != tt.expectedMessage {
+			t.Errorf("Wrong error message. expected=%q, got=%q", tt.expectedMessage, errors[0])
+		}
+	}
+}
+
+func TestRecipeDeclarationParsing(t *testing.T) {
+	input := `recipe add(x, y = 1) { x + y; }
+	rc(x) { x }(1);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	decl, ok := program.Statements[0].(*ast.RecipeDeclaration)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.RecipeDeclaration. got=%T", program.Statements[0])
+	}
+
+	if !testIdentifier(t, decl.Name, "add") {
+		return
+	}
+
+	if len(decl.Recipe.Parameters) != 2 {
+		t.Fatalf("recipe parameters wrong. want 2, got=%d", len(decl.Recipe.Parameters))
+	}
+
+	bodyStmt := decl.Recipe.Body.Statements[0].(*ast.ExpressionStatement)
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+
+	if decl.String() != "recipe add(x, y = 1)(x + y)" {
+		t.Errorf("decl.String() wrong. got=%q", decl.String())
+	}
+
+	if _, ok := program.Statements[1].(*ast.ExpressionStatement); !ok {
+		t.Fatalf("program.Statements[1] is not ast.ExpressionStatement. got=%T", program.Statements[1])
+	}
+}
+
+func TestLoopParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x; }", "while ((x < 10)) { x }"},
+		{"for (x in [1, 2]) { if (x) { break; } }", "for (x in [1, 2]) { ifx break; }"},
+		{"for (k, v in h) { continue; };", "for (k, v in h) { continue; }"},
+		{"while (true) { rc() { 1 }; break; }", "while (true) { rc()1break; }"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"if (true) { continue }", "1:13: continue outside of a loop"},
+		{"while (true) { rc() { break; } }", "1:23: break outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("Expected 1 parser error for %q, got=%d (%v)", tt.input, len(errors), errors)
+		}
+
+		if errors[0].String() != tt.expectedMessage {
+			t.Errorf("Wrong error message. expected=%q, got=%q", tt.expectedMessage, errors[0])
+		}
+	}
+}
+
+func TestAssignExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedOperator string
+	}{
+		{"x to 5;", ""},
+		{"x = 5;", ""},
+		{"x -= 5;", "-"},
+		{"h[\"k\"] %= 5;", "%"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		assign, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T", stmt.Expression)
+		}
+
+		if assign.Operator != tt.expectedOperator {
+			t.Errorf("assign.Operator wrong. expected=%q, got=%q", tt.expectedOperator, assign.Operator)
+		}
+
+		testIntegerLiteral(t, assign.Value, 5)
+	}
+
+	l := lexer.New("1 + x to 5;")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 || p.Errors()[0].Code != diagnostic.INVALID_ASSIGNMENT {
+		t.Errorf("Expected an invalid assignment error, got=%v", p.Errors())
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -745,7 +996,8 @@ func TestParsingHashLiteralStringKeys(t *testing.T) {
 		"three": 3,
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("Key is not ast.StringLiteral, got=%T", key)
@@ -781,7 +1033,8 @@ func TestParsingHashLiteralIntegerKeys(t *testing.T) {
 		3: 3,
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("Key is not ast.IntegerLiteral, got=%T", key)
@@ -823,7 +1076,8 @@ func TestParsingHashLiteralWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("Key is not ast.StringLiteral, got=%T", key)
@@ -862,7 +1116,8 @@ func TestParsingHashLiteralBooleanKeys(t *testing.T) {
 		false: 0,
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("Key is not ast.Boolean, got=%T", key)
@@ -893,6 +1148,123 @@ func TestParsingEmptyHashLiteral(t *testing.T) {
 	}
 }
 
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"bake x 5;", "main.pie:1:8: Expected next token to be to, got INT instead"},
+		{"add(1,\n  2;", "main.pie:2:4: Expected next token to be ), got ; instead"},
+		{"\n\n  };", "main.pie:3:3: No prefix parse function for } found"},
+		{"5; /* oops", "main.pie:1:4: Unterminated block comment"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("main.pie", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("Expected parser errors for %q, got none", tt.input)
+		}
+
+		if errors[0].String() != tt.expectedMessage {
+			t.Errorf("Wrong error message. expected=%q, got=%q", tt.expectedMessage, errors[0])
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements int
+	}{
+		{
+			"bake x 5; bake y to 2; y;",
+			[]string{"1:8: Expected next token to be to, got INT instead"},
+			2,
+		},
+		{
+			`bake h to {"a" 1, "b": 2};
+			bake z to add(1, 2;
+			z;`,
+			[]string{
+				"1:16: Expected next token to be :, got INT instead",
+				"2:22: Expected next token to be ), got ; instead",
+			},
+			1,
+		},
+		{
+			`bake f to rc(x) {
+				if (x { 1 };
+				bake y to ;
+				x
+			};
+			f(1);`,
+			[]string{
+				"2:11: Expected next token to be ), got { instead",
+				"3:15: No prefix parse function for ; found",
+			},
+			2,
+		},
+		{
+			"bake x to 5 @ 3; x",
+			[]string{"1:13: Illegal character \"@\""},
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("Wrong number of errors for %q. expected=%d, got=%d (%v)",
+				tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+
+		for i, expected := range tt.expectedErrors {
+			if errors[i].String() != expected {
+				t.Errorf("Wrong error message. expected=%q, got=%q", expected, errors[i])
+			}
+		}
+
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("Wrong number of statements for %q. expected=%d, got=%d",
+				tt.input, tt.expectedStatements, len(program.Statements))
+		}
+	}
+}
+
+func TestDiagnosticDetails(t *testing.T) {
+	l := lexer.New("add(1, 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("Expected 1 error, got=%d (%v)", len(errors), errors)
+	}
+
+	err := errors[0]
+	if err.Code != diagnostic.UNEXPECTED_TOKEN {
+		t.Errorf("Wrong code. expected=%q, got=%q", diagnostic.UNEXPECTED_TOKEN, err.Code)
+	}
+
+	if err.Start.Column != 9 || err.End.Column != 10 {
+		t.Errorf("Wrong span. expected=9-10, got=%d-%d", err.Start.Column, err.End.Column)
+	}
+
+	if err.Hint == "" {
+		t.Errorf("Expected a hint for a missing closing paren")
+	}
+}
+
 // Private functions
 func testBakeStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "bake" {
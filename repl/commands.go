@@ -0,0 +1,187 @@
+package repl
+
+import (
+	"cottagepie/evaluator"
+	"cottagepie/lexer"
+	"cottagepie/object"
+	"cottagepie/parser"
+	"cottagepie/token"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+const HELP = `Commands:
+  :env             list the bindings of the session with their types
+  :type expr       run expr and show the type of its value
+  :ast expr        show the syntax tree of expr
+  :tokens expr     show the tokens of expr
+  :load file.pie   run a file in the session
+  :save file.pie   write the inputs that ran successfully to a file
+  :reset           forget every binding and input
+  :help            show this help
+`
+
+// command runs a line starting with a colon, eg: ":load recipes.pie"
+func (s *session) command(line string) {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i != -1 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch name {
+	case ":env":
+		s.env()
+	case ":type":
+		s.withArgument(name, "expr", arg, s.typeOf)
+	case ":ast":
+		s.withArgument(name, "expr", arg, s.dumpAst)
+	case ":tokens":
+		s.withArgument(name, "expr", arg, s.dumpTokens)
+	case ":load":
+		s.withArgument(name, "file.pie", arg, s.load)
+	case ":save":
+		s.withArgument(name, "file.pie", arg, s.save)
+	case ":reset":
+		s.eng.Reset()
+		s.history = nil
+		fmt.Fprintln(s.out, "Session reset")
+	case ":help":
+		fmt.Fprint(s.out, HELP)
+	default:
+		fmt.Fprintf(s.out, "Unknown command %s, see :help\n", name)
+	}
+}
+
+func (s *session) withArgument(name, usage, arg string, fn func(arg string)) {
+	if arg == "" {
+		fmt.Fprintf(s.out, "Usage: %s %s\n", name, usage)
+		return
+	}
+	fn(arg)
+}
+
+func (s *session) env() {
+	globals := s.eng.Globals()
+	names := globals.Names()
+	if len(names) == 0 {
+		fmt.Fprintln(s.out, "Nothing baked yet")
+		return
+	}
+
+	for _, name := range names {
+		value, _ := globals.Get(name)
+		fmt.Fprintf(s.out, "%s: %s\n", name, value.Type())
+	}
+}
+
+func (s *session) typeOf(source string) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return
+	}
+
+	evaluated := s.eng.Run(program)
+	switch {
+	case evaluated == nil:
+		fmt.Fprintln(s.out, "No value")
+	case evaluated.Type() == object.ERROR_OBJ:
+		fmt.Fprintln(s.out, evaluated.Inspect())
+	default:
+		fmt.Fprintln(s.out, evaluated.Type())
+	}
+}
+
+func (s *session) dumpTokens(source string) {
+	l := lexer.New(source)
+
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%s %s %q\n", tok.Pos, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+
+	for _, err := range l.Errors() {
+		fmt.Fprintln(s.out, err)
+	}
+}
+
+func (s *session) dumpAst(source string) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return
+	}
+
+	s.dumpNode("Statements", reflect.ValueOf(program.Statements), "")
+}
+
+var tokenType = reflect.TypeOf(token.Token{})
+var commentsType = reflect.TypeOf([]token.Comment{})
+
+// dumpNode prints a node and its fields one per line, the fields indented under it.
+// Tokens are left out, the node types and their other fields already show what they held
+func (s *session) dumpNode(label string, value reflect.Value, indent string) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		fmt.Fprintf(s.out, "%s%s: %s\n", indent, label, value.Type().Name())
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" || field.Type == tokenType || field.Type == commentsType {
+				continue
+			}
+			s.dumpNode(field.Name, value.Field(i), indent+"  ")
+		}
+
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			s.dumpNode(fmt.Sprintf("%s[%d]", label, i), value.Index(i), indent)
+		}
+
+	case reflect.String:
+		fmt.Fprintf(s.out, "%s%s: %q\n", indent, label, value.String())
+
+	default:
+		fmt.Fprintf(s.out, "%s%s: %v\n", indent, label, value.Interface())
+	}
+}
+
+// words are what Tab completes: keywords, built-ins and the names bound in the session
+func (s *session) words() []string {
+	words := append(token.Keywords(), evaluator.BuiltInNames()...)
+	return append(words, s.eng.Globals().Names()...)
+}
+
+func (s *session) load(file string) {
+	source, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(s.out, "Cannot load %s: %s\n", file, err)
+		return
+	}
+	s.run(file, string(source))
+}
+
+func (s *session) save(file string) {
+	source := ""
+	if len(s.history) > 0 {
+		source = strings.Join(s.history, "\n") + "\n"
+	}
+
+	if err := ioutil.WriteFile(file, []byte(source), 0644); err != nil {
+		fmt.Fprintf(s.out, "Cannot save %s: %s\n", file, err)
+		return
+	}
+	fmt.Fprintf(s.out, "Saved %d inputs to %s\n", len(s.history), file)
+}
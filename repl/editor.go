@@ -0,0 +1,386 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keys the editor handles, besides the escape sequences of arrows, Home, End and Delete
+const (
+	KEY_CTRL_A    = 1
+	KEY_CTRL_B    = 2
+	KEY_CTRL_C    = 3
+	KEY_CTRL_D    = 4
+	KEY_CTRL_E    = 5
+	KEY_CTRL_F    = 6
+	KEY_CTRL_G    = 7
+	KEY_CTRL_H    = 8
+	KEY_TAB       = 9
+	KEY_NEWLINE   = 10
+	KEY_CTRL_K    = 11
+	KEY_CTRL_L    = 12
+	KEY_ENTER     = 13
+	KEY_CTRL_N    = 14
+	KEY_CTRL_P    = 16
+	KEY_CTRL_R    = 18
+	KEY_CTRL_U    = 21
+	KEY_CTRL_W    = 23
+	KEY_ESCAPE    = 27
+	KEY_BACKSPACE = 127
+)
+
+// errInterrupted is returned by ReadLine when Ctrl-C gives up on the line being typed
+var errInterrupted = errors.New("interrupted")
+
+// editor reads lines key by key from a terminal in raw mode: the cursor moves with the
+// arrows, Up and Down go through the history, Ctrl-R searches it and Tab completes words
+type editor struct {
+	in    io.RuneReader
+	out   io.Writer
+	width int // columns of the terminal, 0 when unknown
+
+	history *history
+	words   func() []string // the words Tab completes, eg: keywords and bound names
+
+	prompt   string
+	line     []rune
+	pos      int    // position of the cursor in line
+	browsing int    // the history line shown, len(history.lines) for the line being typed
+	draft    []rune // the line being typed while browsing the history
+}
+
+func newEditor(in io.RuneReader, out io.Writer, h *history, words func() []string) *editor {
+	return &editor{in: in, out: out, history: h, words: words}
+}
+
+// ReadLine shows prompt and returns the line once Enter is pressed. Ctrl-D on an empty
+// line returns io.EOF and Ctrl-C errInterrupted
+func (e *editor) ReadLine(prompt string) (string, error) {
+	e.prompt, e.line, e.pos = prompt, nil, 0
+	e.browsing, e.draft = len(e.history.lines), nil
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		// The key ending a search is handled like any other, eg: Enter runs the match
+		if r == KEY_CTRL_R {
+			if r, err = e.search(); err != nil {
+				return "", err
+			}
+		}
+
+		switch r {
+		case KEY_ENTER, KEY_NEWLINE:
+			return e.accept(), nil
+		case KEY_CTRL_C:
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case KEY_CTRL_D:
+			if len(e.line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteForward()
+		case KEY_BACKSPACE, KEY_CTRL_H:
+			e.deleteBackward()
+		case KEY_TAB:
+			e.complete()
+		case KEY_CTRL_A:
+			e.pos = 0
+		case KEY_CTRL_E:
+			e.pos = len(e.line)
+		case KEY_CTRL_B:
+			e.moveLeft()
+		case KEY_CTRL_F:
+			e.moveRight()
+		case KEY_CTRL_K:
+			e.line = e.line[:e.pos]
+		case KEY_CTRL_U:
+			e.line, e.pos = e.line[e.pos:], 0
+		case KEY_CTRL_W:
+			e.deleteWord()
+		case KEY_CTRL_L:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case KEY_CTRL_P:
+			e.browse(-1)
+		case KEY_CTRL_N:
+			e.browse(1)
+		case KEY_ESCAPE:
+			if err := e.escape(); err != nil {
+				return "", err
+			}
+		default:
+			if unicode.IsPrint(r) {
+				e.insert([]rune{r})
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+func (e *editor) accept() string {
+	line := string(e.line)
+	e.pos = len(e.line)
+	e.refresh()
+	io.WriteString(e.out, "\r\n")
+
+	e.history.add(line)
+	return line
+}
+
+// escape handles the escape sequence sent by arrows, Home, End and Delete, eg: ESC [ A for Up
+func (e *editor) escape() error {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return err
+	}
+
+	var param strings.Builder
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return err
+		}
+		if r < '0' || r > '9' {
+			break
+		}
+		param.WriteRune(r)
+	}
+
+	switch {
+	case r == 'A':
+		e.browse(-1)
+	case r == 'B':
+		e.browse(1)
+	case r == 'C':
+		e.moveRight()
+	case r == 'D':
+		e.moveLeft()
+	case r == 'H' || (r == '~' && (param.String() == "1" || param.String() == "7")):
+		e.pos = 0
+	case r == 'F' || (r == '~' && (param.String() == "4" || param.String() == "8")):
+		e.pos = len(e.line)
+	case r == '~' && param.String() == "3":
+		e.deleteForward()
+	}
+	return nil
+}
+
+func (e *editor) insert(runes []rune) {
+	line := make([]rune, 0, len(e.line)+len(runes))
+	line = append(line, e.line[:e.pos]...)
+	line = append(line, runes...)
+	e.line = append(line, e.line[e.pos:]...)
+	e.pos += len(runes)
+}
+
+func (e *editor) moveLeft() {
+	if e.pos > 0 {
+		e.pos -= 1
+	}
+}
+
+func (e *editor) moveRight() {
+	if e.pos < len(e.line) {
+		e.pos += 1
+	}
+}
+
+func (e *editor) deleteBackward() {
+	if e.pos > 0 {
+		e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+		e.pos -= 1
+	}
+}
+
+func (e *editor) deleteForward() {
+	if e.pos < len(e.line) {
+		e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+	}
+}
+
+// deleteWord deletes the word before the cursor and the spaces after it
+func (e *editor) deleteWord() {
+	start := e.pos
+	for start > 0 && unicode.IsSpace(e.line[start-1]) {
+		start -= 1
+	}
+	for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+		start -= 1
+	}
+	e.line = append(e.line[:start], e.line[e.pos:]...)
+	e.pos = start
+}
+
+// browse shows an older (-1) or newer (1) history line, going past the newest one gets
+// back the line that was being typed
+func (e *editor) browse(direction int) {
+	target := e.browsing + direction
+	if target < 0 || target > len(e.history.lines) {
+		return
+	}
+
+	if e.browsing == len(e.history.lines) {
+		e.draft = e.line
+	}
+	e.browsing = target
+
+	if target == len(e.history.lines) {
+		e.line = e.draft
+	} else {
+		e.line = []rune(e.history.lines[target])
+	}
+	e.pos = len(e.line)
+}
+
+// search looks for the query typed after Ctrl-R in the history, newest first, pressing
+// Ctrl-R again finds an older match. Ctrl-G or Ctrl-C leave the line as it was, any other
+// key keeps the match and is returned to be handled, 0 when the search was cancelled
+func (e *editor) search() (rune, error) {
+	original, originalPos := e.line, e.pos
+	var query []rune
+	match := len(e.history.lines)
+
+	// find looks for the newest match older than the line at from
+	find := func(from int) bool {
+		if from > len(e.history.lines) {
+			from = len(e.history.lines)
+		}
+		for i := from - 1; i >= 0; i-- {
+			if strings.Contains(e.history.lines[i], string(query)) {
+				match = i
+				return true
+			}
+		}
+		return false
+	}
+
+	failed := false
+	for {
+		shown := ""
+		if match < len(e.history.lines) {
+			shown = e.history.lines[match]
+		}
+		status := "reverse-i-search"
+		if failed {
+			status = "failed " + status
+		}
+		fmt.Fprintf(e.out, "\r(%s)`%s': %s\x1b[K", status, string(query), shown)
+
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case r == KEY_CTRL_R:
+			failed = !find(match)
+		case r == KEY_BACKSPACE || r == KEY_CTRL_H:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				match = len(e.history.lines)
+				failed = !find(match)
+			}
+		case r == KEY_CTRL_G || r == KEY_CTRL_C:
+			e.line, e.pos = original, originalPos
+			return 0, nil
+		case unicode.IsPrint(r):
+			query = append(query, r)
+			// The current match may still match the longer query
+			failed = !find(match + 1)
+		default:
+			if match < len(e.history.lines) {
+				e.line = []rune(e.history.lines[match])
+				e.pos = len(e.line)
+			}
+			return r, nil
+		}
+	}
+}
+
+// complete completes the word before the cursor with the words starting with it: the
+// only one, or their common beginning. When that adds nothing the words are listed
+func (e *editor) complete() {
+	start := e.pos
+	for start > 0 && isWordRune(e.line[start-1]) {
+		start -= 1
+	}
+	prefix := string(e.line[start:e.pos])
+	if prefix == "" {
+		return
+	}
+
+	candidates := completions(prefix, e.words())
+	if len(candidates) == 0 {
+		io.WriteString(e.out, "\a")
+		return
+	}
+
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(common) > len(prefix) {
+		e.insert([]rune(common[len(prefix):]))
+		return
+	}
+	if len(candidates) > 1 {
+		io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+}
+
+// completions returns the distinct words starting with prefix in alphabetical order
+func completions(prefix string, words []string) []string {
+	seen := map[string]bool{}
+	candidates := []string{}
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) && !seen[word] {
+			seen[word] = true
+			candidates = append(candidates, word)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// refresh redraws the prompt and line, scrolling the line sideways when it doesn't fit
+// the terminal so the cursor stays visible
+func (e *editor) refresh() {
+	promptWidth := utf8.RuneCountInString(e.prompt)
+	start, end := 0, len(e.line)
+
+	if e.width > 0 {
+		available := e.width - promptWidth - 1
+		if available < 1 {
+			available = 1
+		}
+		if e.pos > available {
+			start = e.pos - available
+		}
+		if end-start > available {
+			end = start + available
+		}
+	}
+
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", e.prompt, string(e.line[start:end]))
+	if column := promptWidth + e.pos - start; column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
@@ -0,0 +1,99 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Older lines are dropped from the history once it has more than MAX_HISTORY lines
+const MAX_HISTORY = 1000
+
+// history holds the lines typed in the REPL, oldest first. With a file they are kept
+// across sessions, without one they only last as long as the REPL
+type history struct {
+	lines []string
+	file  string
+}
+
+// historyFile is where the history is kept, in the user config dir, eg: ~/.config/cottagepie/history
+func historyFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cottagepie", "history")
+}
+
+// loadHistory reads the lines kept in file, a missing file is an empty history
+func loadHistory(file string) *history {
+	h := &history{file: file}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+
+	// The file only ever gets appended to, rewrite it once it grew too long
+	if len(h.lines) > MAX_HISTORY {
+		h.lines = h.lines[len(h.lines)-MAX_HISTORY:]
+		h.rewrite()
+	}
+	return h
+}
+
+// add records a line, blank lines and repeats of the previous line are left out
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+
+	h.lines = append(h.lines, line)
+	if len(h.lines) > MAX_HISTORY {
+		h.lines = h.lines[1:]
+	}
+	h.append(line)
+}
+
+// Failing to write the history file doesn't stop the REPL, the lines are still kept in memory
+
+func (h *history) append(line string) {
+	if h.file == "" {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.file), 0755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
+
+func (h *history) rewrite() {
+	if h.file == "" {
+		return
+	}
+
+	f, err := os.OpenFile(h.file, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, line := range h.lines {
+		w.WriteString(line + "\n")
+	}
+	w.Flush()
+}
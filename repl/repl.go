@@ -1,56 +1,234 @@
 package repl
 
 import (
-	"bufio"
-	"cottagepie/evaluator"
+	"cottagepie/diagnostic"
+	"cottagepie/engine"
 	"cottagepie/lexer"
 	"cottagepie/object"
 	"cottagepie/parser"
-	"fmt"
+	"cottagepie/token"
 	"io"
+	"os"
+	"strings"
 )
 
 const PROMPT = ">> "
+const CONTINUATION_PROMPT = ".. "
 const ERROR_MESSAGE = `
 #========================#
 #      ERRORS FOUND      #
 #========================#
 `
 
+// Start reads lines from in and writes results to out, programs also do their I/O through them
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	book := object.NewCookbook()
+	ctx := object.NewContext(in, out, out)
+	eng, _ := engine.NewWithContext(engine.EVALUATOR, ctx)
+	StartWithEngine(ctx, eng)
+}
+
+// StartWithEngine runs every line with the given engine, eg: the bytecode vm. Lines are read
+// from the context stdin, which is shared with the input built-in, and results go to its stdout.
+// Incomplete input, eg: a recipe whose body isn't closed yet, is continued on the next lines
+func StartWithEngine(ctx *object.Context, eng engine.Engine) {
+	s := &session{ctx: ctx, eng: eng, out: ctx.Stdout, pretty: object.DefaultPrettyOptions()}
+	s.loop(plainReader{ctx})
+}
+
+// StartTerminal runs the REPL like StartWithEngine, with line editing, a history kept
+// across sessions and Tab completion when stdin is a terminal. Anywhere else it falls
+// back to reading plain lines. Values are colored when stdout is a terminal too, unless
+// the NO_COLOR environment variable is set
+func StartTerminal(ctx *object.Context, eng engine.Engine, stdin *os.File) {
+	term, err := newTerminal(stdin)
+	if err != nil {
+		StartWithEngine(ctx, eng)
+		return
+	}
+
+	s := &session{ctx: ctx, eng: eng, out: ctx.Stdout, pretty: object.DefaultPrettyOptions()}
+	if width := term.width(); width > 0 {
+		s.pretty.Width = width
+	}
+	if stdout, ok := ctx.Stdout.(*os.File); ok && os.Getenv("NO_COLOR") == "" {
+		_, err := newTerminal(stdout)
+		s.pretty.Color = err == nil
+	}
+	e := newEditor(ctx.Stdin(), ctx.Stdout, loadHistory(historyFile()), s.words)
+	s.loop(&terminalReader{term: term, editor: e})
+}
+
+// lineReader reads the lines of the REPL, showing the prompt first
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+type plainReader struct {
+	ctx *object.Context
+}
+
+func (r plainReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.ctx.Stdout, prompt)
+	return r.ctx.ReadLine()
+}
+
+// terminalReader puts the terminal in raw mode while the editor reads a line, programs
+// get it back as it was, eg: for input to echo what is typed
+type terminalReader struct {
+	term   *terminal
+	editor *editor
+}
+
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	if err := r.term.raw(); err != nil {
+		return "", err
+	}
+	defer r.term.restore()
+
+	r.editor.width = r.term.width()
+	return r.editor.ReadLine(prompt)
+}
+
+func (s *session) loop(reader lineReader) {
+	var lines []string
 
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		prompt := PROMPT
+		if len(lines) > 0 {
+			prompt = CONTINUATION_PROMPT
+		}
+
+		line, err := reader.ReadLine(prompt)
+		if err == errInterrupted {
+			lines = nil
+			continue
+		}
+		if err != nil {
+			// Whatever was left unfinished still gets its errors shown
+			if len(lines) > 0 {
+				s.run("", strings.Join(lines, "\n"))
+			}
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			s.command(strings.TrimSpace(line))
+			continue
+		}
+
+		// A blank line gives up on incomplete input, unless it is inside brackets, eg: in a
+		// pasted recipe body. Two blank lines in a row always give up
+		blank := strings.TrimSpace(line) == ""
+		giveUp := blank && len(lines) > 0 && (strings.TrimSpace(lines[len(lines)-1]) == "" || !unclosed(strings.Join(lines, "\n")))
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		lines = append(lines, line)
+		source := strings.Join(lines, "\n")
+		if !giveUp && incomplete(source) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, book)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		lines = nil
+		s.run("", source)
+	}
+}
+
+// session is the state of a REPL: the engine running the inputs and the inputs that
+// ran successfully, for :save
+type session struct {
+	ctx     *object.Context
+	eng     engine.Engine
+	out     io.Writer
+	pretty  object.PrettyOptions // how results are printed
+	history []string
+}
+
+// run parses and runs source, file names it in error positions when it was loaded from one
+func (s *session) run(file string, source string) {
+	l := lexer.NewWithFile(file, source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return
+	}
+
+	evaluated := s.eng.Run(program)
+	if evaluated != nil {
+		io.WriteString(s.out, object.Pretty(evaluated, s.pretty))
+		io.WriteString(s.out, "\n")
+	}
+
+	if err, ok := evaluated.(*object.Error); ok {
+		io.WriteString(s.out, err.StackTrace())
+		return
+	}
+
+	if strings.TrimSpace(source) != "" {
+		s.history = append(s.history, strings.TrimRight(source, "\n"))
+	}
+}
+
+// incomplete tells if more lines could complete source: it doesn't parse and either leaves
+// brackets, a string or a block comment open, or the parser ran into the end of the input
+func incomplete(source string) bool {
+	if unclosed(source) {
+		return true
+	}
+
+	p := parser.New(lexer.New(source))
+	p.ParseProgram()
+
+	end := endOf(source)
+	for _, err := range p.Errors() {
+		if err.Start == end {
+			return true
+		}
+	}
+	return false
+}
+
+// unclosed tells if source ends inside brackets, a string or a block comment
+func unclosed(source string) bool {
+	l := lexer.New(source)
+	depth := 0
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth += 1
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth -= 1
 		}
 	}
+
+	for _, err := range l.Errors() {
+		if err.Code == diagnostic.UNTERMINATED_STRING || err.Code == diagnostic.UNTERMINATED_COMMENT {
+			return true
+		}
+	}
+	return depth > 0
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+// endOf is the position of the end of source, where the parser reports a missing token
+func endOf(source string) token.Position {
+	l := lexer.New(source)
+
+	tok := l.NextToken()
+	for tok.Type != token.EOF {
+		tok = l.NextToken()
+	}
+	return tok.Pos
+}
+
+func printParserErrors(out io.Writer, errors []diagnostic.Diagnostic) {
 	io.WriteString(out, ERROR_MESSAGE)
 	io.WriteString(out, "\nEncountered errors:\n")
 
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.String()+"\n")
+		if err.Hint != "" {
+			io.WriteString(out, "\t\thint: "+err.Hint+"\n")
+		}
 	}
 }
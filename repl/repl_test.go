@@ -0,0 +1,248 @@
+package repl
+
+import (
+	"bytes"
+	"cottagepie/engine"
+	"cottagepie/object"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMultiLineInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2\n", ">> 3\n>> "},
+		{"bake f to rc(x) {\n  x * 2\n}\nf(21)\n", ">> .. .. >> 42\n>> "},
+		{"1 +\n2\n", ">> .. 3\n>> "},
+		{"\"cottage\n pie\"\n", ">> .. cottage\n pie\n>> "},
+		{"[1,\n\n2]\n", ">> .. .. [1, 2]\n>> "},
+		{"/* a\ncomment */ 5\n", ">> .. 5\n>> "},
+		{"bake x to\n\n", ">> .. " + ERROR_MESSAGE},
+		{"rc() {\n\n\n", ">> .. .. recipe() {}\n>> "},
+		{"rc() {\n", ">> .. recipe() {}\n"},
+		{"1)\n", ">> " + ERROR_MESSAGE},
+		{"rc(x) { bake y to x; y * 2 }\n", ">> recipe(x) {\n  bake y to x;\n  y * 2\n}\n>> "},
+		{"[\"a\", {\"b\": [1]}]\n", ">> [\"a\", {\"b\": [1]}]\n>> "},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+
+		if !strings.HasPrefix(out.String(), tt.expected) {
+			t.Errorf("Wrong output for %q. expected prefix=%q, got=%q", tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"bake x to 1;", false},
+		{"bake f to rc(x) {", true},
+		{"f(1, ", true},
+		{"[1, [2]", true},
+		{"bake s to 'pie", true},
+		{"/* never closed", true},
+		{"bake x to", true},
+		{"if (true) { 1 } else", true},
+		{"1 + * 2", false},
+		{"}", false},
+		{"// only a comment", false},
+	}
+
+	for _, tt := range tests {
+		if actual := incomplete(tt.input); actual != tt.expected {
+			t.Errorf("Wrong result for %q. expected=%t, got=%t", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := filepath.Join(dir, "session.pie")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":env", "Nothing baked yet\n"},
+		{"bake x to [1];\nrecipe add(a, b) {\n  a + b\n}\n:env", "add: RECIPE\nx: ARRAY\n"},
+		{"bake x to 1;\nbake y to 1 / 0;\n:env", "x: INTEGER\n"},
+		{":type 1 + 0.5", "FLOAT\n"},
+		{":type 1 / 0", "ERROR>> 1:3: Division by zero: 1 / 0\n"},
+		{":type", "Usage: :type expr\n"},
+		{":tokens -x", "1:1 - \"-\"\n1:2 IDENT \"x\"\n1:3 EOF \"\"\n"},
+		{":ast -x", "Statements[0]: ExpressionStatement\n  Expression: PrefixExpression\n    Operator: \"-\"\n    Right: Identifier\n      Value: \"x\"\n"},
+		{"bake x to 2;\n:reset\n:env", "Session reset\n>> Nothing baked yet\n"},
+		{"bake x to 2;\nx +\n1\nx / 0\n:save " + saved, "Saved 2 inputs to " + saved + "\n"},
+		{":load " + saved + "\n:env", "3\n>> x: INTEGER\n"},
+		{":load " + filepath.Join(dir, "missing.pie"), "Cannot load "},
+		{":bake", "Unknown command :bake, see :help\n"},
+		{":help", HELP},
+	}
+
+	for _, engineName := range []string{engine.EVALUATOR, engine.VM} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			ctx := object.NewContext(strings.NewReader(tt.input), &out, &out)
+			eng, _ := engine.NewWithContext(engineName, ctx)
+			StartWithEngine(ctx, eng)
+
+			// Only the output of the last lines matters, the final prompt is followed by nothing
+			outputs := strings.Split(out.String(), PROMPT)
+			lines := strings.Count(tt.expected, PROMPT) + 1
+			actual := strings.Join(outputs[len(outputs)-1-lines:len(outputs)-1], PROMPT)
+
+			if !strings.HasPrefix(actual, tt.expected) {
+				t.Errorf("Wrong output for %q with %s. expected=%q, got=%q", tt.input, engineName, tt.expected, actual)
+			}
+		}
+	}
+
+	source, err := ioutil.ReadFile(saved)
+	if err != nil || string(source) != "bake x to 2;\nx +\n1\n" {
+		t.Errorf("Wrong saved session. got=%q, %v", source, err)
+	}
+}
+
+func TestEditor(t *testing.T) {
+	words := func() []string { return []string{"bake", "break", "plates", "platef", "pantry"} }
+
+	tests := []struct {
+		keys     string
+		history  []string
+		expected []string
+	}{
+		{"bake x\r", nil, []string{"bake x"}},
+		{"ac\x1b[Db\r", nil, []string{"abc"}},               // Left
+		{"bc\x01a\x05d\r", nil, []string{"abcd"}},           // Ctrl-A and Ctrl-E
+		{"ab\x1b[H\x1b[3~\x1b[Fc\r", nil, []string{"bc"}},   // Home, Delete and End
+		{"abcd\x02\x02\x0b\r", nil, []string{"ab"}},         // Ctrl-B and Ctrl-K
+		{"abcd\x02\x15\r", nil, []string{"d"}},              // Ctrl-U
+		{"bake pie to\x17\x17x\r", nil, []string{"bake x"}}, // Ctrl-W
+		{"abc\x7f\x7f\r", nil, []string{"a"}},               // Backspace
+		{"pié\x7fe\r", nil, []string{"pie"}},                // Runes, not bytes
+		{"\x1b[A\x1b[A\r", []string{"one", "two"}, []string{"one"}},
+		{"new\x1b[A\x1b[B\r", []string{"one"}, []string{"new"}}, // Down gets back the line being typed
+		{"\x10\x10\x10\x0e\r", []string{"one", "two"}, []string{"two"}},
+		{"\x12tw\r", []string{"two", "three", "four"}, []string{"two"}},
+		{"\x12o\x12\r", []string{"one", "two", "four"}, []string{"two"}},
+		{"\x12zz\x07x\r", []string{"one"}, []string{"x"}},    // Ctrl-G cancels the search
+		{"\x12on\x01x\r", []string{"one"}, []string{"xone"}}, // Other keys keep the match
+		{"pla\tf\r", nil, []string{"platef"}},
+		{"ba\t\r", nil, []string{"bake"}},
+		{"x to br\t\r", nil, []string{"x to break"}},
+		{"zz\t\r", nil, []string{"zz"}},
+		{"a\x03b\r", nil, []string{"", "b"}},              // Ctrl-C gives up on the line
+		{"a\x04\x02\x04\r\x04", nil, []string{"", "EOF"}}, // Ctrl-D deletes, then ends on an empty line
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := newEditor(strings.NewReader(tt.keys), &out, &history{lines: tt.history}, words)
+
+		var lines []string
+		for len(lines) < len(tt.expected) {
+			line, err := e.ReadLine(PROMPT)
+			if err == errInterrupted {
+				line = ""
+			} else if err == io.EOF {
+				line = "EOF"
+			} else if err != nil {
+				t.Fatalf("ReadLine failed for %q: %s", tt.keys, err)
+			}
+			lines = append(lines, line)
+		}
+
+		if strings.Join(lines, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("Wrong lines for %q. expected=%q, got=%q", tt.keys, tt.expected, lines)
+		}
+	}
+}
+
+func TestEditorCompletionList(t *testing.T) {
+	var out bytes.Buffer
+	words := func() []string { return []string{"plates", "platef", "pie"} }
+	e := newEditor(strings.NewReader("plate\t\r"), &out, &history{}, words)
+	e.ReadLine(PROMPT)
+
+	if !strings.Contains(out.String(), "\r\nplatef  plates\r\n") {
+		t.Errorf("The candidates weren't listed, got=%q", out.String())
+	}
+}
+
+func TestEditorScrolling(t *testing.T) {
+	var out bytes.Buffer
+	e := newEditor(strings.NewReader(strings.Repeat("a", 20)+"b\r"), &out, &history{}, func() []string { return nil })
+	e.width = 10
+	e.ReadLine(PROMPT)
+
+	// 10 columns leave room for 6 characters after the prompt, the cursor included
+	if !strings.HasSuffix(out.String(), "\r>> aaaaab\x1b[K\r\x1b[9C\r\n") {
+		t.Errorf("The line didn't scroll, got=%q", out.String())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cottagepie", "history")
+
+	h := loadHistory(file)
+	for _, line := range []string{"one", "one", "  ", "two"} {
+		h.add(line)
+	}
+
+	if lines := strings.Join(loadHistory(file).lines, "|"); lines != "one|two" {
+		t.Errorf("Wrong history after reloading. expected=%q, got=%q", "one|two", lines)
+	}
+
+	for i := 0; i < MAX_HISTORY; i++ {
+		h.add(strconv.Itoa(i))
+	}
+	reloaded := loadHistory(file)
+	if len(reloaded.lines) != MAX_HISTORY || reloaded.lines[0] != "0" {
+		t.Errorf("The history wasn't truncated, got %d lines starting with %q", len(reloaded.lines), reloaded.lines[0])
+	}
+
+	contents, _ := ioutil.ReadFile(file)
+	if strings.Count(string(contents), "\n") != MAX_HISTORY {
+		t.Errorf("The history file wasn't rewritten, it has %d lines", strings.Count(string(contents), "\n"))
+	}
+}
+
+func TestStartTerminalWithoutTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("1 + 1\n")
+	f.Seek(0, io.SeekStart)
+
+	var out bytes.Buffer
+	ctx := object.NewContext(f, &out, &out)
+	eng, _ := engine.NewWithContext(engine.EVALUATOR, ctx)
+	StartTerminal(ctx, eng, f)
+
+	if out.String() != ">> 2\n>> " {
+		t.Errorf("StartTerminal didn't fall back to plain lines, got=%q", out.String())
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package repl
+
+import (
+	"errors"
+	"os"
+)
+
+// terminal is only supported on linux and macOS, elsewhere the REPL reads plain lines
+type terminal struct{}
+
+func newTerminal(f *os.File) (*terminal, error) {
+	return nil, errors.New("line editing isn't supported on this platform")
+}
+
+func (t *terminal) raw() error     { return nil }
+func (t *terminal) restore() error { return nil }
+func (t *terminal) width() int     { return 0 }
@@ -0,0 +1,57 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package repl
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminal switches a terminal between raw mode, where the line editor gets every key as it
+// is typed, and the mode it was in, where programs read their input as usual
+type terminal struct {
+	fd       int
+	original syscall.Termios
+}
+
+// newTerminal fails when f isn't a terminal
+func newTerminal(f *os.File) (*terminal, error) {
+	t := &terminal{fd: int(f.Fd())}
+	if err := ioctl(t.fd, ioctlGetTermios, unsafe.Pointer(&t.original)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *terminal) raw() error {
+	raw := t.original
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	return ioctl(t.fd, ioctlSetTermios, unsafe.Pointer(&raw))
+}
+
+func (t *terminal) restore() error {
+	return ioctl(t.fd, ioctlSetTermios, unsafe.Pointer(&t.original))
+}
+
+// width is the number of columns of the terminal, 0 when it can't tell
+func (t *terminal) width() int {
+	var size struct{ rows, columns, x, y uint16 }
+	if err := ioctl(t.fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0
+	}
+	return int(size.columns)
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -1,10 +1,44 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 type TokenType string
 
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type     TokenType
+	Literal  string
+	Pos      Position
+	Comments []Comment // comments found right before the token
+}
+
+// Comment is a line (//) or block (/* */) comment, Text includes the delimiters
+type Comment struct {
+	Text string
+	Pos  Position
+}
+
+// Position is the location of a token in its source, lines and columns start at 1
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 const (
@@ -14,6 +48,7 @@ const (
 	// Identifiers & literals
 	IDENT  = "IDENT" // add, foobar, x, y, ...
 	INT    = "INT"   // 1234567
+	FLOAT  = "FLOAT" // 12.5, 1e-3
 	STRING = "STRING"
 
 	// Operators
@@ -23,11 +58,18 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 	LT       = "<"
 	GT       = ">"
 	EQ       = "=="
 	NOT_EQ   = "!="
 
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -38,27 +80,48 @@ const (
 	RBRACE    = "}"
 	LBRACKET  = "["
 	RBRACKET  = "]"
+	ELLIPSIS  = "..."
 
 	// Keywords
-	RECIPE = "RECIPE"
-	BAKE   = "BAKE"
-	TRUE   = "TRUE"
-	FALSE  = "FALSE"
-	IF     = "IF"
-	ELSE   = "ELSE"
-	SERVES = "SERVES"
+	RECIPE   = "RECIPE"
+	BAKE     = "BAKE"
+	TRUE     = "TRUE"
+	FALSE    = "FALSE"
+	IF       = "IF"
+	ELSE     = "ELSE"
+	SERVES   = "SERVES"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 )
 
 var keywords = map[string]TokenType{
-	"rc":     RECIPE,
-	"recipe": RECIPE,
-	"bake":   BAKE,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"serves": SERVES,
-	"to":     ASSIGN,
+	"rc":       RECIPE,
+	"recipe":   RECIPE,
+	"bake":     BAKE,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"serves":   SERVES,
+	"to":       ASSIGN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+}
+
+// Keywords returns every keyword in alphabetical order, eg: for completion
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,68 @@
+package vm
+
+import "cottagepie/object"
+
+const ITERATOR_OBJ = "ITERATOR"
+
+// iterator walks the iterable of a for loop the way the evaluator does, it only
+// ever lives on the stack for the duration of the loop
+type iterator struct {
+	next   func() (key, value object.Object, ok bool)
+	isHash bool
+}
+
+func (it *iterator) Type() object.ObjectType        { return ITERATOR_OBJ }
+func (it *iterator) Inspect() string                { return "iterator" }
+func (it *iterator) Equal(other object.Object) bool { return it == other }
+
+// newIterator yields each (index, element) of an array or string, each (key, value)
+// of a hash or each (i, i) from 0 to n - 1 for an integer n
+func newIterator(iterable object.Object) (*iterator, *object.Error) {
+	i := 0
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		elements := iterable.Elements
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= len(elements) {
+				return nil, nil, false
+			}
+			i += 1
+			return &object.Integer{Value: int64(i - 1)}, elements[i-1], true
+		}}, nil
+
+	case *object.String:
+		chars := []rune(iterable.Value)
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if i >= len(chars) {
+				return nil, nil, false
+			}
+			i += 1
+			return &object.Integer{Value: int64(i - 1)}, &object.String{Value: string(chars[i-1])}, true
+		}}, nil
+
+	case *object.Hash:
+		pairs := append([]object.HashPair{}, iterable.Pairs()...)
+		return &iterator{isHash: true, next: func() (object.Object, object.Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+			i += 1
+			return pairs[i-1].Key, pairs[i-1].Value, true
+		}}, nil
+
+	case *object.Integer:
+		limit := iterable.Value
+		return &iterator{next: func() (object.Object, object.Object, bool) {
+			if int64(i) >= limit {
+				return nil, nil, false
+			}
+			index := &object.Integer{Value: int64(i)}
+			i += 1
+			return index, index, true
+		}}, nil
+
+	default:
+		return nil, newError("Cannot iterate over %s", iterable.Type())
+	}
+}
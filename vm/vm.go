@@ -0,0 +1,684 @@
+package vm
+
+import (
+	"context"
+	"cottagepie/code"
+	"cottagepie/compiler"
+	"cottagepie/evaluator"
+	"cottagepie/object"
+	"cottagepie/token"
+	"fmt"
+)
+
+const (
+	STACK_SIZE = 2048    // initial size, the stack grows as needed
+	MAX_STACK  = 1 << 20 // values on the stack at once
+)
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+	globalIndex map[string]int
+
+	stack []object.Object
+	sp    int // the next free slot, the top of the stack is stack[sp-1]
+
+	frames []Frame // reused between calls, pointers into it don't survive a pushFrame
+
+	ctx *object.Context // given to built-ins for their I/O, it also keeps the run within its limits
+}
+
+// Frame is a recipe call, locals live in env so the closures created during the call can share them
+type Frame struct {
+	closure     *object.Closure
+	env         *object.Env
+	ip          int // the next instruction to run
+	pc          int // the instruction that made the last call, for stack traces
+	basePointer int // where the recipe being called was on the stack
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, nil)
+}
+
+// NewWithGlobals runs the bytecode against the globals of previous runs, for the REPL
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	if len(globals) < len(bytecode.GlobalNames) {
+		grown := make([]object.Object, len(bytecode.GlobalNames))
+		copy(grown, globals)
+		globals = grown
+	}
+
+	globalIndex := make(map[string]int, len(bytecode.GlobalNames))
+	for i, name := range bytecode.GlobalNames {
+		globalIndex[name] = i
+	}
+
+	main := Frame{closure: &object.Closure{Recipe: bytecode.Main}}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     globals,
+		globalNames: bytecode.GlobalNames,
+		globalIndex: globalIndex,
+		stack:       make([]object.Object, STACK_SIZE),
+		frames:      []Frame{main},
+		ctx:         object.DefaultContext(),
+	}
+}
+
+// SetContext makes the program do its I/O through ctx instead of the process stdin and stdout
+func (vm *VM) SetContext(ctx *object.Context) {
+	vm.ctx = ctx
+}
+
+// Globals returns the global slots, to be given to the next NewWithGlobals
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// RunContext runs the program like Run, stopping with an error object once ctx is cancelled or
+// the run goes over one of the limits. Every instruction counts as a step
+func (vm *VM) RunContext(ctx context.Context, limits object.Limits) object.Object {
+	end := vm.ctx.Begin(ctx, limits)
+	defer end()
+
+	return vm.Run()
+}
+
+// Run executes the program and returns what the evaluator would: the value of the
+// last statement, nil if it has none, or the runtime error that stopped it
+func (vm *VM) Run() object.Object {
+	previous := vm.ctx.SetCaller(vm.call)
+	defer vm.ctx.SetCaller(previous)
+
+	return vm.run(0)
+}
+
+// run executes instructions until the frame at index base returns, base is 0 for the main
+// program and the frame of the recipe being called back for a nested run, see call
+func (vm *VM) run(base int) object.Object {
+	frame := &vm.frames[len(vm.frames)-1]
+	ins := frame.closure.Recipe.Instructions
+
+	for {
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+		err := vm.ctx.Step()
+		if err != nil {
+			return vm.fail(err, ip, base)
+		}
+
+		switch op {
+		case code.OpConstant:
+			frame.ip = ip + 3
+			err = vm.push(vm.constants[code.ReadUint16(ins[ip+1:])])
+
+		case code.OpPop:
+			frame.ip = ip + 1
+			vm.sp -= 1
+
+		case code.OpNull:
+			frame.ip = ip + 1
+			err = vm.push(evaluator.NULL)
+
+		case code.OpTrue:
+			frame.ip = ip + 1
+			err = vm.push(evaluator.TRUE)
+
+		case code.OpFalse:
+			frame.ip = ip + 1
+			err = vm.push(evaluator.FALSE)
+
+		case code.OpBinary:
+			frame.ip = ip + 2
+			right := vm.stack[vm.sp-1]
+			left := vm.stack[vm.sp-2]
+			vm.sp -= 2
+			err = vm.pushNew(binaryOperation(ins[ip+1], left, right))
+
+		case code.OpMinus, code.OpBang:
+			frame.ip = ip + 1
+			operator := "-"
+			if op == code.OpBang {
+				operator = "!"
+			}
+			vm.sp -= 1
+			err = vm.pushNew(evaluator.PrefixOperation(operator, vm.stack[vm.sp]))
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[ip+1:]))
+
+		case code.OpJumpNotTruthy:
+			frame.ip = ip + 3
+			vm.sp -= 1
+			if !evaluator.IsTruthy(vm.stack[vm.sp]) {
+				frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			}
+
+		case code.OpJumpIfLocalSet:
+			frame.ip = ip + 5
+			if frame.env.Slots[code.ReadUint16(ins[ip+1:])] != nil {
+				frame.ip = int(code.ReadUint16(ins[ip+3:]))
+			}
+
+		case code.OpIterInit:
+			frame.ip = ip + 1
+			vm.sp -= 1
+			var it *iterator
+			if it, err = newIterator(vm.stack[vm.sp]); err == nil {
+				err = vm.push(it)
+			}
+
+		case code.OpIterNext:
+			frame.ip = ip + 4
+			it := vm.stack[vm.sp-1].(*iterator)
+			key, value, ok := it.next()
+			switch {
+			case !ok:
+				frame.ip = int(code.ReadUint16(ins[ip+2:]))
+			case ins[ip+1] == code.ITERATE_PAIR:
+				if err = vm.push(key); err == nil {
+					err = vm.push(value)
+				}
+			case it.isHash:
+				err = vm.push(key)
+			default:
+				err = vm.push(value)
+			}
+
+		case code.OpGetGlobal:
+			frame.ip = ip + 3
+			index := code.ReadUint16(ins[ip+1:])
+			value := vm.globals[index]
+			if value == nil {
+				value, err = vm.lookupUnbound(nil, vm.globalNames[index])
+			}
+			if err == nil {
+				err = vm.push(value)
+			}
+
+		case code.OpSetGlobal:
+			frame.ip = ip + 3
+			vm.sp -= 1
+			vm.globals[code.ReadUint16(ins[ip+1:])] = vm.stack[vm.sp]
+
+		case code.OpAssignGlobal:
+			frame.ip = ip + 4
+			err = vm.assign(nil, vm.globals, vm.globalNames, int(code.ReadUint16(ins[ip+1:])), int(ins[ip+3]))
+
+		case code.OpGetLocal:
+			frame.ip = ip + 3
+			index := code.ReadUint16(ins[ip+1:])
+			value := frame.env.Slots[index]
+			if value == nil {
+				value, err = vm.lookupUnbound(frame.env, frame.env.Names[index])
+			}
+			if err == nil {
+				err = vm.push(value)
+			}
+
+		case code.OpSetLocal:
+			frame.ip = ip + 3
+			vm.sp -= 1
+			frame.env.Slots[code.ReadUint16(ins[ip+1:])] = vm.stack[vm.sp]
+
+		case code.OpAssignLocal:
+			frame.ip = ip + 4
+			err = vm.assign(frame.env, frame.env.Slots, frame.env.Names, int(code.ReadUint16(ins[ip+1:])), int(ins[ip+3]))
+
+		case code.OpGetOuter:
+			frame.ip = ip + 4
+			env := frame.env.Outer(int(ins[ip+1]))
+			index := code.ReadUint16(ins[ip+2:])
+			value := env.Slots[index]
+			if value == nil {
+				value, err = vm.lookupUnbound(env, env.Names[index])
+			}
+			if err == nil {
+				err = vm.push(value)
+			}
+
+		case code.OpAssignOuter:
+			frame.ip = ip + 5
+			env := frame.env.Outer(int(ins[ip+1]))
+			err = vm.assign(env, env.Slots, env.Names, int(code.ReadUint16(ins[ip+2:])), int(ins[ip+4]))
+
+		case code.OpName:
+			frame.ip = ip + 3
+			if closure, ok := vm.stack[vm.sp-1].(*object.Closure); ok && closure.Name == "" {
+				closure.Name = vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String).Value
+			}
+
+		case code.OpArray:
+			frame.ip = ip + 3
+			count := int(code.ReadUint16(ins[ip+1:]))
+			elements := make([]object.Object, count)
+			copy(elements, vm.stack[vm.sp-count:vm.sp])
+			vm.sp -= count
+			err = vm.pushNew(&object.Array{Elements: elements})
+
+		case code.OpHash:
+			frame.ip = ip + 3
+			count := int(code.ReadUint16(ins[ip+1:]))
+			var hash *object.Hash
+			if hash, err = buildHash(vm.stack[vm.sp-count : vm.sp]); err == nil {
+				vm.sp -= count
+				err = vm.pushNew(hash)
+			}
+
+		case code.OpIndex:
+			frame.ip = ip + 1
+			index := vm.stack[vm.sp-1]
+			left := vm.stack[vm.sp-2]
+			vm.sp -= 2
+			err = vm.pushResult(evaluator.IndexOperation(left, index))
+
+		case code.OpSetIndex:
+			frame.ip = ip + 2
+			err = vm.setIndex(int(ins[ip+1]))
+
+		case code.OpClosure:
+			frame.ip = ip + 3
+			recipe := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.CompiledRecipe)
+			err = vm.pushNew(&object.Closure{Recipe: recipe, Env: frame.env})
+
+		case code.OpCall:
+			frame.ip = ip + 2
+			frame.pc = ip
+			argc := int(ins[ip+1])
+
+			switch callee := vm.stack[vm.sp-1-argc].(type) {
+			case *object.Closure:
+				if err = vm.pushFrame(callee, argc); err == nil {
+					frame = &vm.frames[len(vm.frames)-1]
+					ins = frame.closure.Recipe.Instructions
+				}
+			case *object.BuiltIn:
+				// The built-in may have called back recipes and grown the frames
+				err = vm.callBuiltIn(callee, argc)
+				frame = &vm.frames[len(vm.frames)-1]
+			default:
+				err = newError("Not a function: %s", callee.Type())
+			}
+
+		case code.OpReturnValue, code.OpReturn:
+			var result object.Object
+			if op == code.OpReturnValue {
+				vm.sp -= 1
+				result = vm.stack[vm.sp]
+			}
+
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return result
+			}
+
+			if result == nil {
+				result = evaluator.NULL
+			}
+			vm.sp = frame.basePointer
+			if len(vm.frames) == base {
+				return result
+			}
+			vm.stack[vm.sp] = result
+			vm.sp += 1
+
+			frame = &vm.frames[len(vm.frames)-1]
+			ins = frame.closure.Recipe.Instructions
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			err = newError("Unknown instruction: %v", def)
+		}
+
+		if err != nil {
+			return vm.fail(err, ip, base)
+		}
+	}
+}
+
+func (vm *VM) push(obj object.Object) *object.Error {
+	if vm.sp >= len(vm.stack) {
+		if len(vm.stack) >= MAX_STACK {
+			return newError("Stack overflow: more than %d values on the stack", MAX_STACK)
+		}
+		grown := make([]object.Object, len(vm.stack)*2)
+		copy(grown, vm.stack)
+		vm.stack = grown
+	}
+
+	vm.stack[vm.sp] = obj
+	vm.sp += 1
+	return nil
+}
+
+// pushResult pushes the result of an operation shared with the evaluator, unless it failed
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	return vm.push(result)
+}
+
+// pushNew pushes a result the operation allocated, accounting for it in the memory limit
+func (vm *VM) pushNew(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	if err := vm.ctx.Allocate(object.SizeOf(result)); err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
+// lookupUnbound resolves a name whose slot in env was never set, going on the way the evaluator
+// looks up a name missing from the current Cookbook: the recipes around, globals then built-ins.
+// env is nil for globals
+func (vm *VM) lookupUnbound(env *object.Env, name string) (object.Object, *object.Error) {
+	if slot := enclosingSlot(env, name); slot != nil {
+		return *slot, nil
+	}
+
+	if index, ok := vm.globalIndex[name]; ok && vm.globals[index] != nil {
+		return vm.globals[index], nil
+	}
+
+	if built_in, ok := evaluator.LookupBuiltIn(name); ok {
+		return built_in, nil
+	}
+
+	return nil, newError("Identifier not found: " + name)
+}
+
+// assign stores the value on top of the stack in a slot of env, applying the compound operator
+// first if there is one. The value stays on the stack as the result of the assignment
+func (vm *VM) assign(env *object.Env, slots []object.Object, names []string, index int, operator int) *object.Error {
+	name := names[index]
+	target := &slots[index]
+
+	// An unset slot means the name isn't bound in this scope yet, like Cookbook.Assign fall back
+	// to the recipes around then the global
+	if *target == nil {
+		target = enclosingSlot(env, name)
+		if global, ok := vm.globalIndex[name]; ok && target == nil && vm.globals[global] != nil {
+			target = &vm.globals[global]
+		}
+	}
+
+	value := vm.stack[vm.sp-1]
+	if operator != 0 {
+		var current object.Object
+		if target != nil {
+			current = *target
+		} else {
+			var err *object.Error
+			if current, err = vm.lookupUnbound(env, name); err != nil {
+				return err
+			}
+		}
+
+		value = binaryOperation(byte(operator-1), current, value)
+		if err, ok := value.(*object.Error); ok {
+			return err
+		}
+		if err := vm.ctx.Allocate(object.SizeOf(value)); err != nil {
+			return err
+		}
+		vm.stack[vm.sp-1] = value
+	}
+
+	if target == nil {
+		return newError("Cannot assign to undefined name: %s", name)
+	}
+
+	*target = value
+	return nil
+}
+
+// enclosingSlot finds the set slot of name in the envs of the recipes around env, nil when
+// none of them has it yet
+func enclosingSlot(env *object.Env, name string) *object.Object {
+	if env == nil {
+		return nil
+	}
+
+	for outer := env.Parent; outer != nil; outer = outer.Parent {
+		for i, slotName := range outer.Names {
+			if slotName == name && outer.Slots[i] != nil {
+				return &outer.Slots[i]
+			}
+		}
+	}
+	return nil
+}
+
+// setIndex pops the target, index and value of an index assignment and pushes the assigned value
+func (vm *VM) setIndex(operator int) *object.Error {
+	value := vm.stack[vm.sp-1]
+	index := vm.stack[vm.sp-2]
+	left := vm.stack[vm.sp-3]
+	vm.sp -= 3
+
+	if operator != 0 {
+		current := evaluator.IndexOperation(left, index)
+		if err, ok := current.(*object.Error); ok {
+			return err
+		}
+
+		value = binaryOperation(byte(operator-1), current, value)
+		if err, ok := value.(*object.Error); ok {
+			return err
+		}
+		if err := vm.ctx.Allocate(object.SizeOf(value)); err != nil {
+			return err
+		}
+	}
+
+	if left.Type() == object.HASH_OBJ {
+		if err := vm.ctx.Allocate(object.PAIR_SIZE); err != nil {
+			return err
+		}
+	}
+	return vm.pushResult(evaluator.IndexAssignment(left, index, value))
+}
+
+// binaryOperation handles integers without the type dispatch of the evaluator, the most
+// common case in loops, everything else is left to the evaluator
+func binaryOperation(operator byte, left, right object.Object) object.Object {
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			switch operator {
+			case code.OPERATOR_LESS:
+				return nativeBoolToBooleanObject(left.Value < right.Value)
+			case code.OPERATOR_GREATER:
+				return nativeBoolToBooleanObject(left.Value > right.Value)
+			case code.OPERATOR_EQUAL:
+				return nativeBoolToBooleanObject(left.Value == right.Value)
+			case code.OPERATOR_NOT_EQUAL:
+				return nativeBoolToBooleanObject(left.Value != right.Value)
+			default:
+				return evaluator.IntegerArithmetic(code.BINARY_OPERATORS[operator], left.Value, right.Value)
+			}
+		}
+	}
+
+	return evaluator.InfixOperation(code.BINARY_OPERATORS[operator], left, right)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
+
+func buildHash(keysAndValues []object.Object) (*object.Hash, *object.Error) {
+	hash := object.NewHash()
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := keysAndValues[i]
+		value := keysAndValues[i+1]
+
+		hashKey, ok := object.HashKeyOf(key)
+		if !ok {
+			return nil, newError("Unusable as a hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey, object.HashPair{Key: object.FreezeKey(key), Value: value})
+	}
+
+	return hash, nil
+}
+
+// pushFrame binds the arguments to the locals of a new call like the evaluator binds them
+// in a new Cookbook, the defaults of the missing ones are evaluated by the recipe itself
+func (vm *VM) pushFrame(closure *object.Closure, argc int) *object.Error {
+	recipe := closure.Recipe
+
+	min, max := closure.Arity()
+	if err := evaluator.CheckArity(closure.Name, min, max, argc); err != nil {
+		return err
+	}
+
+	// The main program has a frame too but isn't a recipe call
+	if len(vm.frames)-1 >= vm.ctx.MaxDepth() {
+		return vm.ctx.DepthError()
+	}
+	if err := vm.ctx.Allocate(object.OBJECT_SIZE + object.ELEMENT_SIZE*int64(recipe.NumLocals)); err != nil {
+		return err
+	}
+
+	basePointer := vm.sp - 1 - argc
+	args := vm.stack[basePointer+1 : vm.sp]
+	env := newEnv(recipe.NumLocals)
+	env.Names = recipe.LocalNames
+	env.Parent = closure.Env
+
+	if argc <= recipe.NumParameters {
+		copy(env.Slots, args)
+	} else {
+		copy(env.Slots, args[:recipe.NumParameters])
+	}
+
+	if recipe.Variadic {
+		rest := []object.Object{}
+		if argc > recipe.NumParameters {
+			rest = append(rest, args[recipe.NumParameters:]...)
+		}
+		restArray := &object.Array{Elements: rest}
+		if err := vm.ctx.Allocate(object.SizeOf(restArray)); err != nil {
+			return err
+		}
+		env.Slots[recipe.NumParameters] = restArray
+	}
+
+	vm.sp = basePointer
+	vm.frames = append(vm.frames, Frame{closure: closure, env: env, basePointer: basePointer})
+	return nil
+}
+
+// smallEnv lets recipes with few locals get their Env and slots in a single allocation
+type smallEnv struct {
+	env   object.Env
+	slots [SMALL_ENV_SLOTS]object.Object
+}
+
+const SMALL_ENV_SLOTS = 4
+
+func newEnv(size int) *object.Env {
+	if size > SMALL_ENV_SLOTS {
+		return &object.Env{Slots: make([]object.Object, size)}
+	}
+
+	small := &smallEnv{}
+	small.env.Slots = small.slots[:size]
+	return &small.env
+}
+
+func (vm *VM) callBuiltIn(built_in *object.BuiltIn, argc int) *object.Error {
+	// Built-ins may keep their arguments, they get their own copy of the stack slots
+	args := make([]object.Object, argc)
+	copy(args, vm.stack[vm.sp-argc:vm.sp])
+	vm.sp -= argc + 1
+
+	result := built_in.Fn(vm.ctx, args...)
+	if result == nil {
+		result = evaluator.NULL
+	}
+	return vm.pushNew(result)
+}
+
+// call is the Caller of built-ins: a closure gets its frame on top of the others and runs
+// in a nested loop until it returns. A failed call leaves the stack and frames as they were
+func (vm *VM) call(rc object.Object, args []object.Object) object.Object {
+	switch callee := rc.(type) {
+	case *object.Closure:
+		sp, base := vm.sp, len(vm.frames)
+		if err := vm.push(callee); err != nil {
+			return err
+		}
+		for _, arg := range args {
+			if err := vm.push(arg); err != nil {
+				vm.sp = sp
+				return err
+			}
+		}
+
+		if err := vm.pushFrame(callee, len(args)); err != nil {
+			vm.sp = sp
+			return err
+		}
+
+		result := vm.run(base)
+		if _, ok := result.(*object.Error); ok {
+			vm.sp, vm.frames = sp, vm.frames[:base]
+		}
+		return result
+
+	case *object.BuiltIn:
+		result := callee.Fn(vm.ctx, args...)
+		if result == nil {
+			return evaluator.NULL
+		}
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+		if err := vm.ctx.Allocate(object.SizeOf(result)); err != nil {
+			return err
+		}
+		return result
+
+	default:
+		return newError("Not a function: %s", rc.Type())
+	}
+}
+
+// fail positions the error at the instruction that raised it and records the recipe
+// calls it went through, innermost first, like the evaluator does while unwinding.
+// A nested run only records its own frames, the run it is nested in records the rest
+func (vm *VM) fail(err *object.Error, ip int, base int) *object.Error {
+	frame := &vm.frames[len(vm.frames)-1]
+	frame.pc = ip
+
+	if !err.Pos.IsValid() {
+		err.Pos = frame.position()
+	}
+
+	for i := len(vm.frames) - 1; i > 0 && i >= base; i-- {
+		callee := &vm.frames[i]
+		caller := &vm.frames[i-1]
+		err.Trace = append(err.Trace, object.Frame{Name: callee.closure.Name, CallSite: caller.position()})
+	}
+
+	return err
+}
+
+func (f *Frame) position() token.Position {
+	return f.closure.Recipe.Positions[f.pc]
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
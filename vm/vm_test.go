@@ -0,0 +1,316 @@
+package vm
+
+import (
+	"context"
+	"cottagepie/ast"
+	"cottagepie/compiler"
+	"cottagepie/evaluator"
+	"cottagepie/lexer"
+	"cottagepie/object"
+	"cottagepie/parser"
+	"testing"
+	"time"
+)
+
+// The evaluator is the reference implementation, every program has to give the same result on both engines
+func TestDifferential(t *testing.T) {
+	tests := []string{
+		// Literals and operators
+		"5", "-5", "!true", "!!5", "1 + 2 * 3 - 4 / 2", "7 % 3", "(1 + 2) * 3",
+		"1.5 * 2", "1 + 0.5", "10 / 4", "10.0 / 4", "3 < 4.5", "2 == 2.0", "1 != 2",
+		`"cottage" + "pie"`, `"a" == "a"`, `"a" != "b"`, `"a" < "b"`, "true == false", "null", "[1, 2 * 2, 3 + 3]",
+		`{"one": 1, "two": 2}["two"]`, "[1, 2, 3][1]", "[1, 2, 3][5]", `{1: "a", true: "b"}[true]`,
+		`bake h to {[1, "a"]: 3}; h[[1, "a"]]`, `bake h to {}; h[[0, 1]] = 2; h[[0, 1]] += 1; h[[0, 1]]`,
+		`bake h to {[1]: 1}; for (k in h) { k[0] = 2 }`, `{[{}]: 1}`,
+		`{"c": 1, "a": 2, "b": 3}`, `bake h to {"x": 1}; h["a"] = 2; h["x"] = 3; h`,
+		`bake log to [0]; bake note to rc(x) { log = push(log, x); x }; {note(1): note(2), note(3): note(4)}; log`,
+		"[1, [2.0]] == [1.0, [2]]", `{"a": [1]} != {"a": [2]}`, "bake f to rc() { 1 }; f == f",
+		"bake fs to [0]; for (i in 2) { fs = push(fs, rc() { i }) }; fs[1] == fs[2]",
+
+		// Errors, with their position
+		"5 + true;", "-true", `"a" - "b"`, "foobar", "1 / 0", "5 % 0", "9223372036854775807 + 1",
+		"-(-9223372036854775807 - 1)", `{[1]: 2}`, `{"a": 1}[[1]]`, "1[0]", "5(1)",
+		"length(1)", "bake x to 5; x(); 10",
+
+		// Conditionals and blocks
+		"if (true) { 10 }", "if (false) { 10 }", "if (1 < 2) { 10 } else { 20 }",
+		"if (null) { 1 } else { 2 }", "if (true) { if (true) { serves 10; } serves 1; }",
+		"bake x to if (true) { 1; 2 }; x", "if (true) { bake y to 1 }", "if (true) {} else {}",
+
+		// Bindings
+		"bake a to 5; bake b to a * 2; a + b", "bake a to 5; bake a to a + 1; a",
+		"bake x to 1;", "serves 5; 10", "1; serves 2; 3",
+
+		// Recipes and closures
+		"rc(x) { x * 2 }(21)", "bake add to rc(a, b) { a + b }; add(1, add(2, 3))",
+		"bake f to rc() { 5 }; f", "rc() {}()", "rc() { bake x to 1 }()", "rc() { serves 1; 2 }()",
+		`bake adder to rc(x) { rc(y) { x + y } }; bake add2 to adder(2); add2(40)`,
+		`bake outer to rc() { bake f to rc() { y }; bake y to 5; f() }; outer()`,
+		`bake make to rc() { bake count to 0; rc() { count += 1 } };
+		 bake next to make(); next(); next(); next()`,
+		`bake a to rc() { bake b to rc() { rc() { x } }; bake x to 3; b()() }; a()`,
+		`bake fib to rc(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`,
+		`bake x to 10; bake f to rc() { plates(x); bake x to 2; x }; f() + x`,
+		`bake f to rc() { bake x to "outer"; bake g to rc() { plates(x); bake x to "inner"; x }; g() }; f()`,
+		`bake f to rc() { bake x to 0; bake g to rc() { x = 1; bake x to 2; x }; [g(), x] }; f()`,
+		`bake f to rc() { bake x to 0; bake g to rc() { x += 5; bake x to 2; x }; [g(), x] }; f()`,
+		`bake greet to rc(name, greeting = "hello") { greeting + " " + name }; greet("pie")`,
+		`bake greet to rc(name, greeting = "hello") { greeting + " " + name }; greet("pie", "hi")`,
+		`bake f to rc(a, b = a * 2) { a + b }; f(1)`,
+		`bake f to rc(first, ...rest) { [first, rest] }; f(1, 2, 3)`,
+		`bake f to rc(...rest) { rest }; f()`,
+		`bake f to rc(a, b = 1, ...rest) { [a, b, rest] }; [f(1), f(1, 2), f(1, 2, 3, 4)]`,
+		`bake f to rc(a, b) { a }; f(1)`, `bake f to rc(a, b = 1) { a }; f()`,
+		`bake f to rc(a, ...b) { a }; f()`, `rc(a) { a }(1, 2)`,
+		`bake f to rc(x = 1 / 0) { x }; f()`,
+		`bake f to rc(x, x) { x }; f(1, 2)`,
+		`bake add to rc(a, b) { a + b }; add`, `rc(x = 1, ...r) { x }`,
+		`bake f to rc() { rc() { 1 } }; bake g to f(); g`,
+
+		// Declarations
+		`is_even(10); recipe is_even(n) { if (n == 0) { true } else { is_odd(n - 1) } }
+		 recipe is_odd(n) { if (n == 0) { false } else { is_even(n - 1) } }`,
+		`recipe f() { 1 }`, `5; recipe f() { 1 }`,
+		`bake f to rc() { g(); recipe g() { 10 } }; f()`,
+
+		// Loops
+		`bake i to 0; bake sum to 0; while (i < 10) { sum += i; i += 1; }; sum`,
+		`bake i to 0; while (true) { i += 1; if (i == 5) { break; } }; i`,
+		`bake out to []; for (x in [1, 2, 3, 4]) { if (x == 2) { continue; } out = push(out, x); }; out`,
+		`bake total to 0; for (i, x in [10, 20]) { total += i * x }; total`,
+		`bake s to ""; for (c in "pie") { s = c + s }; s`,
+		`bake s to 0; for (i, c in "héllo") { s += i }; s`,
+		`bake total to 0; for (i in 5) { total += i }; total`,
+		`bake total to 0; for (k, v in {"a": 1, "b": 2}) { total += v }; total`,
+		`bake keys to 0; for (k in {"a": 1, "b": 2}) { keys += length(k) }; keys`,
+		`for (x in 3) { x }`, `while (false) { 1 }`, `for (x in true) { x }`,
+		`bake f to rc() { for (x in [1, 2, 3]) { if (x == 2) { serves x * 10 } }; 0 }; f()`,
+		`for (x in [1, 2]) { serves x }`,
+		`bake f to rc(n) { bake total to 0; for (i in n) { for (j in n) { if (j > i) { break } total += 1 } }; total }; f(4)`,
+		`bake fs to []; for (i in 3) { fs = push(fs, rc() { i }) }; fs[0]()`,
+
+		// Assignment
+		`bake x to 1; x = 2; x`, `bake x to 1; x += 2`, `y = 1`, `y += 1`, `length += 1`,
+		`bake a to [1, 2]; a[0] = 5; a`, `bake a to [1, 2]; a[1] *= 10; a`, `bake a to [1]; a[3] = 1`,
+		`bake h to {"a": 1}; h["b"] = 2; h["a"] += 1; [h["a"], h["b"]]`, `"abc"[0] = 1`,
+		`bake x to 1; bake f to rc() { x = 5 }; f(); x`,
+		`bake f to rc() { bake local to 1; rc() { local *= 7; local } }; bake g to f(); g(); g()`,
+		`bake x to 1; bake y to x = 3; [x, y]`,
+
+		// Built-ins
+		`length("pie")`, `first([1, 2])`, `last([1, 2])`, `rest([1, 2, 3])`, `push([1], 2)`,
+		`int(2.7)`, `float(3)`, `round(2.567, 2)`, `floor(-1.5)`, `ceil(1.2)`, `length`,
+		`bake length to rc(x) { 42 }; length("pie")`, `push([], 1)`,
+		`[keys({"b": 1, "a": 2}), values({"b": 1}), items({"a": [1]}), length({})]`,
+		`bake h to {"a": 1, "b": 2}; [has(h, "a"), delete(h, "a"), merge(h, {"c": 3}), h]`,
+		`[slice([1, 2, 3], 1), concat([1], [2]), reverse([1, 2]), index_of([1, 2], 2), contains([1], 2)]`,
+		`[insert([1, 3], 1, 2), remove([1, 2], 0), range(3), range(6, 0, -2)]`,
+		`remove([], 0)`, `range(1, 2, 0)`,
+		`[map([1, 2], rc(x) { x * 2 }), filter([1, 2, 3], rc(x) { x != 2 }), reduce([1, 2, 3], rc(a, x) { a + x }, 10)]`,
+		`bake n to 0; each([1, 2], rc(x) { n += x }); [n, any([1], rc(x) { x > 0 }), all([1], rc(x) { x > 1 })]`,
+		`[sort([3, 1, 2]), sort([1, 3, 2], rc(a, b) { b - a }), sort_by(["bb", "a"], length), group_by([1, 2, 3], rc(x) { x % 2 })]`,
+		`zip([1, 2], [3, 4])`, `map([[1], [2, 3]], rc(xs) { map(xs, rc(x) { x + length(xs) }) })`,
+		`bake make to rc(k) { rc(x) { x * k } }; map([1, 2], make(3))`,
+		`map([1, 0], rc(x) { 1 / x })`, `map([1], rc(a, b) { a })`, `sort([1, 2], rc(a, b) { plates })`,
+		"recipe half(x) { x / 0 }\nrecipe halves(xs) { map(xs, half) }\nhalves([1])",
+		"bake f to rc(xs) { map(xs, rc(x) { if (x > 1) { g(x) } else { x } }) };\nbake g to rc(x) { f([x - 1])[0] + y };\nf([3])",
+		`map([1, 2], rc(x) { serves x * 3; 0 })`,
+	}
+
+	for _, input := range tests {
+		expected := testEval(input)
+		actual := testRun(t, input)
+
+		if inspect(actual) != inspect(expected) {
+			t.Errorf("vm and evaluator disagree on %q. vm=%s, evaluator=%s", input, inspect(actual), inspect(expected))
+			continue
+		}
+
+		expectedErr, ok := expected.(*object.Error)
+		if ok && expectedErr.StackTrace() != actual.(*object.Error).StackTrace() {
+			t.Errorf("vm and evaluator disagree on the stack trace of %q. vm=%q, evaluator=%q",
+				input, actual.(*object.Error).StackTrace(), expectedErr.StackTrace())
+		}
+	}
+}
+
+func TestStringEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" != "a"`, false},
+		{`bake s to "pie"; "cottage" + s == "cottagepie"`, true},
+		{`"1" == 1`, false},
+	}
+
+	for _, tt := range tests {
+		result := testRun(t, tt.input)
+		if result != nativeBoolToBooleanObject(tt.expected) {
+			t.Errorf("wrong result for %q. want=%t, got=%s", tt.input, tt.expected, inspect(result))
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	input := `bake add to rc(a, b) {
+  a + b;
+};
+bake twice to rc(x) {
+  add(x, x);
+};
+bake run to rc() { twice(true) };
+run();`
+
+	result := testRun(t, input)
+
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object served. got=%T (%+v)", result, result)
+	}
+
+	expected := "\tat add (2:5)\n" +
+		"\tat twice (5:6)\n" +
+		"\tat run (7:25)\n" +
+		"\tat <main> (8:4)\n"
+
+	if errObj.StackTrace() != expected {
+		t.Errorf("Wrong stack trace. expected=%q, got=%q", expected, errObj.StackTrace())
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	result := testRun(t, "bake f to rc(n) { f(n + 1) }; f(0)")
+
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object served. got=%T (%+v)", result, result)
+	}
+
+	expected := "Stack overflow: more than 10000 nested recipe calls"
+	if errObj.Message != expected {
+		t.Errorf("Wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	tests := []struct {
+		input         string
+		limits        object.Limits
+		expectedLimit object.Limit
+	}{
+		{"bake f to rc(n) { f(n + 1) }; f(0)", object.Limits{MaxDepth: 50}, object.DEPTH_LIMIT},
+		{"while (true) {}", object.Limits{MaxSteps: 1000}, object.STEP_LIMIT},
+		{"while (true) {}", object.Limits{Timeout: 10 * time.Millisecond}, object.TIMEOUT},
+		{`bake s to "pie"; while (true) { s = s + s }`, object.Limits{MaxMemory: 1 << 20}, object.MEMORY_LIMIT},
+		{"bake a to [0]; while (true) { a = push(a, 1) }", object.Limits{MaxMemory: 1 << 16}, object.MEMORY_LIMIT},
+		{"bake f to rc(x) { map([x], f) }; f(1)", object.Limits{MaxDepth: 50}, object.DEPTH_LIMIT},
+		{"each([1], rc(x) { while (true) {} })", object.Limits{MaxSteps: 1000}, object.STEP_LIMIT},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error on %q: %s", tt.input, err)
+		}
+
+		result := New(comp.Bytecode()).RunContext(context.Background(), tt.limits)
+
+		errObj, ok := result.(*object.Error)
+		if !ok || errObj.Limit != tt.expectedLimit {
+			t.Errorf("Wrong result for %q. expected a %s error, got=%s", tt.input, tt.expectedLimit, inspect(result))
+		}
+	}
+}
+
+func TestGlobalsAcrossRuns(t *testing.T) {
+	symbols := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	var globals []object.Object
+
+	inputs := []string{
+		"bake x to 40;",
+		"bake add to rc(a, b) { a + b };",
+		"add(x, 2)",
+	}
+
+	var result object.Object
+	for _, input := range inputs {
+		comp := compiler.NewWithState(symbols, constants)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobals(bytecode, globals)
+		result = machine.Run()
+		globals = machine.Globals()
+	}
+
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 42 {
+		t.Fatalf("Wrong result. expected=42, got=%s", inspect(result))
+	}
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	benchmarkEngines(b, `bake fib to rc(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(20)`)
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmarkEngines(b, `bake i to 0; bake total to 0; while (i < 100000) { total += i % 7; i += 1 }; total`)
+}
+
+func benchmarkEngines(b *testing.B, input string) {
+	program := parse(input)
+
+	b.Run("evaluator", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			evaluator.Eval(program, object.NewCookbook())
+		}
+	})
+
+	b.Run("vm", func(b *testing.B) {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			New(bytecode).Run()
+		}
+	})
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testEval(input string) object.Object {
+	return evaluator.Eval(parse(input), object.NewCookbook())
+}
+
+func testRun(t *testing.T, input string) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error on %q: %s", input, err)
+	}
+
+	return New(comp.Bytecode()).Run()
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
+}